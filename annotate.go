@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// notesKey is the metadata key Monzo keeps a transaction's notes under.
+const notesKey = "notes"
+
+// annotation is the body of a request to annotate a transaction. Notes are
+// added after any the transaction already has, and metadata keys that are
+// already set to something else are left alone, unless Replace is set. Notes
+// are only touched when sent, so replacing with empty notes clears them.
+type annotation struct {
+	Notes    *string           `json:"notes"`
+	Metadata map[string]string `json:"metadata"`
+	Replace  bool              `json:"replace"`
+}
+
+// annotationConflictError lists the metadata keys an annotation would have
+// overwritten.
+type annotationConflictError struct {
+	Keys []string
+}
+
+func (e *annotationConflictError) Error() string {
+	return "metadata already set for " + strings.Join(e.Keys, ", ") + ", send replace to overwrite"
+}
+
+// mergeAnnotation works out which metadata to send Monzo to apply a to tx.
+// It returns nothing if tx already has everything in a.
+func mergeAnnotation(tx *monzo.Transaction, a annotation) (map[string]string, error) {
+	changes := map[string]string{}
+	var conflicts []string
+
+	for key, value := range a.Metadata {
+		current, ok := tx.Metadata[key]
+		switch {
+		case ok && current == value:
+		case ok && current != "" && !a.Replace:
+			conflicts = append(conflicts, key)
+		default:
+			changes[key] = value
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, &annotationConflictError{Keys: conflicts}
+	}
+
+	if a.Notes != nil {
+		notes := mergeNotes(tx.Notes, *a.Notes, a.Replace)
+		if notes != tx.Notes {
+			changes[notesKey] = notes
+		}
+	}
+	return changes, nil
+}
+
+// mergeNotes adds extra to the end of notes on a line of its own, unless it
+// is there already.
+func mergeNotes(notes, extra string, replace bool) string {
+	if replace || notes == "" {
+		return extra
+	}
+	if extra == "" {
+		return notes
+	}
+	for _, line := range strings.Split(notes, "\n") {
+		if line == extra {
+			return notes
+		}
+	}
+	return notes + "\n" + extra
+}
+
+// validateAnnotation checks a's metadata keys can be sent to Monzo.
+func validateAnnotation(a annotation) string {
+	if a.Notes == nil && len(a.Metadata) == 0 {
+		return "Send notes or metadata to add"
+	}
+	for key := range a.Metadata {
+		if key == notesKey {
+			return "Set notes with the notes field, not metadata"
+		}
+		if key == "" || strings.ContainsAny(key, "[]") {
+			return "Metadata keys can't be empty or contain brackets"
+		}
+	}
+	return ""
+}
+
+// annotateTransactionHandlerWrapper adds notes and metadata to one of the
+// user's transactions.
+func annotateTransactionHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var a annotation
+		err := json.NewDecoder(c.Request.Body).Decode(&a)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "Body must be JSON with notes and/or a metadata object of strings",
+			})
+			return
+		}
+		if problem := validateAnnotation(a); problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": problem,
+			})
+			return
+		}
+
+		ctx := c.Request.Context()
+		client := userClient(c, api)
+		tx, err := client.Transaction(ctx, c.Param("id"))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		changes, err := mergeAnnotation(tx, a)
+		if conflict, ok := err.(*annotationConflictError); ok {
+			c.JSON(http.StatusConflict, gin.H{
+				"Error": conflict.Error(),
+				"keys":  conflict.Keys,
+			})
+			return
+		}
+
+		if len(changes) > 0 {
+			tx, err = client.AnnotateTransaction(ctx, tx.ID, changes)
+			if err != nil {
+				monzoErrorResponse(c, err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"transaction": tx,
+		})
+	}
+}
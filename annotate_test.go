@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeAnnotation(t *testing.T) {
+	tx := &monzo.Transaction{
+		Notes:    "Lunch",
+		Metadata: map[string]string{"notes": "Lunch", "category": "food"},
+	}
+
+	for _, test := range []struct {
+		annotation annotation
+		changes    map[string]string
+		conflicts  []string
+	}{
+		{annotation{Notes: notes("with Sam")}, map[string]string{"notes": "Lunch\nwith Sam"}, nil},
+		{annotation{Notes: notes("Lunch")}, map[string]string{}, nil},
+		{annotation{Notes: notes("")}, map[string]string{}, nil},
+		{annotation{Notes: notes("Dinner"), Replace: true}, map[string]string{"notes": "Dinner"}, nil},
+		{annotation{Notes: notes(""), Replace: true}, map[string]string{"notes": ""}, nil},
+		{annotation{Metadata: map[string]string{"category": "food", "split": "sam"}}, map[string]string{"split": "sam"}, nil},
+		{annotation{Metadata: map[string]string{"category": "treats", "rule": "x"}}, nil, []string{"category"}},
+		{annotation{Metadata: map[string]string{"category": "treats"}, Replace: true}, map[string]string{"category": "treats"}, nil},
+	} {
+		changes, err := mergeAnnotation(tx, test.annotation)
+		if test.conflicts != nil {
+			assert.Equal(t, &annotationConflictError{Keys: test.conflicts}, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.changes, changes, "Annotation %+v", test.annotation)
+	}
+}
+
+func notes(s string) *string {
+	return &s
+}
+
+func TestAnnotateTransaction(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+	fake.txMetadata["tx_1"] = map[string]string{"notes": "Lunch"}
+
+	browser := &fakeBrowser{server: testServer(fake.config())}
+	browser.login(t, "alice")
+
+	w := browser.patch(t, "/api/transactions/tx_1/notes", `{"notes":"with Sam","metadata":{"split":"sam"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]string{"notes": "Lunch\nwith Sam", "split": "sam"}, fake.txMetadata["tx_1"])
+
+	w = browser.patch(t, "/api/transactions/tx_1/notes", `{"metadata":{"split":"alex"}}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "sam", fake.txMetadata["tx_1"]["split"])
+
+	w = browser.patch(t, "/api/transactions/tx_1/notes", `{"notes":"Dinner","metadata":{"split":"alex"},"replace":true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]string{"notes": "Dinner", "split": "alex"}, fake.txMetadata["tx_1"])
+
+	for _, body := range []string{`{}`, `{"metadata":{"notes":"x"}}`, `{"metadata":{"a[b]":"x"}}`, `{"metadata":{"a":1}}`, `{"replace":true}`} {
+		w = browser.patch(t, "/api/transactions/tx_1/notes", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "Body %s", body)
+	}
+
+	w = browser.patch(t, "/api/transactions/tx_missing/notes", `{"notes":"x"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// userClient is api acting as the user let through by
+// requireApprovalMiddleware, whose token is refreshed first if it has
+// expired.
+func userClient(c *gin.Context, api *monzo.Client) *monzo.Client {
+	return api.WithTokens(c.MustGet(tokenSourceContextKey).(monzo.TokenSource))
+}
+
+// accountsHandlerWrapper lists the user's accounts, optionally only those of
+// the type given in the account_type query parameter.
+func accountsHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		accountType := c.Query("account_type")
+		switch accountType {
+		case "", monzo.AccountTypeRetail, monzo.AccountTypeRetailJoint:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "account_type must be uk_retail or uk_retail_joint",
+			})
+			return
+		}
+
+		accounts, err := userClient(c, api).Accounts(c.Request.Context(), accountType)
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		if accounts == nil {
+			accounts = []monzo.Account{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"accounts": accounts,
+		})
+	}
+}
+
+// balanceHandlerWrapper shows the balance of one of the user's accounts.
+func balanceHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		balance, err := userClient(c, api).Balance(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, balance)
+	}
+}
+
+// transactionsHandlerWrapper lists an account's transactions, fetching as
+// many pages from Monzo as it takes. The since and before query parameters
+// bound them by date, either as a day such as 2018-01-31 or RFC 3339 time.
+// expand=merchant fills in merchant details.
+func transactionsHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		query := monzo.TransactionsQuery{
+			AccountID:      c.Query("account_id"),
+			ExpandMerchant: c.Query("expand") == "merchant",
+		}
+		if query.AccountID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "account_id is required",
+			})
+			return
+		}
+
+		var err error
+		for name, bound := range map[string]*time.Time{"since": &query.Since, "before": &query.Before} {
+			*bound, err = parseTimeParam(c.Query(name))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"Error": name + " must be a date such as 2018-01-31 or an RFC 3339 time",
+				})
+				return
+			}
+		}
+		if !query.Since.IsZero() && !query.Before.IsZero() && !query.Since.Before(query.Before) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "since must be before before",
+			})
+			return
+		}
+
+		transactions := []monzo.Transaction{}
+		it := userClient(c, api).Transactions(c.Request.Context(), query)
+		for it.Next() {
+			transactions = append(transactions, it.Transaction())
+		}
+		if it.Err() != nil {
+			monzoErrorResponse(c, it.Err())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"transactions": transactions,
+		})
+	}
+}
+
+// parseTimeParam reads a query parameter given as a day or an RFC 3339 time.
+// A missing parameter is the zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// monzoErrorResponse passes on Monzo refusing a request because of something
+// the user sent or because access has been revoked, as it has when the
+// user's refresh token is turned down, reports the breaker
+// turning the request away as unavailable, and reports anything else as a
+// bad gateway. The error is left on the context for requireApprovalMiddleware.
+func monzoErrorResponse(c *gin.Context, err error) {
+	monzoErrorResponseWith(c, err, nil)
+}
+
+// monzoErrorResponseWith is monzoErrorResponse with fields added to the
+// body, which is then always an object.
+func monzoErrorResponseWith(c *gin.Context, err error, fields gin.H) {
+	c.Error(err)
+
+	status, message := http.StatusBadGateway, err.Error()
+	if monzo.IsCircuitOpen(err) {
+		status, message = http.StatusServiceUnavailable, "Monzo is unavailable, try again shortly"
+	} else if apiErr, ok := err.(*monzo.APIError); ok {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			status, message = http.StatusUnauthorized, approvalMessage(approvalRevoked)
+		case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
+			status = apiErr.StatusCode
+		}
+	} else if monzo.IsInvalidGrant(err) {
+		status, message = http.StatusUnauthorized, approvalMessage(approvalRevoked)
+	}
+
+	if status == http.StatusBadGateway && fields == nil {
+		c.JSON(status, message)
+		return
+	}
+
+	body := gin.H{"Error": message}
+	for name, value := range fields {
+		body[name] = value
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountsAndBalance(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+
+	w := browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	browser.login(t, "alice")
+
+	w = browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var accounts struct {
+		Accounts []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		} `json:"accounts"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &accounts))
+	assert.Len(t, accounts.Accounts, 1)
+	assert.Equal(t, "acc_alice", accounts.Accounts[0].ID)
+
+	w = browser.get(t, "/api/accounts?account_type=uk_retail_joint")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"accounts":[]}`, w.Body.String())
+
+	w = browser.get(t, "/api/accounts?account_type=uk_prepaid")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = browser.get(t, "/api/accounts/acc_alice/balance")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"balance":5000,"total_balance":6000,"currency":"GBP","spend_today":-250}`, w.Body.String())
+
+	w = browser.get(t, "/api/accounts/acc_bob/balance")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAPIRefreshesExpiredToken(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.expiresIn = 0
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+	logins := monzo.tokenRequests
+
+	monzo.expiresIn = 21600
+	w := browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, logins+1, monzo.tokenRequests, "The expired token should be refreshed first")
+
+	w = browser.get(t, "/api/accounts/acc_alice/balance")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, logins+1, monzo.tokenRequests)
+}
+
+func TestAPINoticesRejectedRefresh(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.expiresIn = 0
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	monzo.rejectRefresh = true
+	w := browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"Error":"access has been revoked, log in again"}`, w.Body.String())
+
+	w = browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"approval":"revoked"`)
+}
+
+func TestAPINeedsApproval(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.unapproved = true
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	w := browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAPINoticesRevokedAccess(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	w := browser.get(t, "/api/accounts/acc_alice/balance")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The user takes access away in the app
+	monzo.mu.Lock()
+	monzo.revoked = true
+	monzo.mu.Unlock()
+
+	w = browser.get(t, "/api/accounts/acc_alice/balance")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// And then approves us again
+	monzo.mu.Lock()
+	monzo.revoked = false
+	monzo.mu.Unlock()
+
+	w = browser.get(t, "/api/accounts/acc_alice/balance")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTransactions(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	w := browser.get(t, "/api/transactions?account_id=acc_alice&since=2018-01-02&before=2018-01-08")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var transactions struct {
+		Transactions []struct {
+			ID string `json:"id"`
+		} `json:"transactions"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &transactions))
+	assert.Len(t, transactions.Transactions, 6*24)
+	assert.Equal(t, "tx_024", transactions.Transactions[0].ID)
+	assert.Equal(t, "tx_167", transactions.Transactions[6*24-1].ID)
+	assert.Equal(t, 2, monzo.transactionPages)
+}
+
+func TestTransactionsValidatesQuery(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	for _, query := range []string{
+		"",
+		"?account_id=acc_alice&since=yesterday",
+		"?account_id=acc_alice&since=2018-01-08&before=2018-01-02",
+	} {
+		w := browser.get(t, "/api/transactions"+query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "Query %q", query)
+	}
+}
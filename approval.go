@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// approvalState tracks Strong Customer Authentication. After logging in a
+// user still has to approve askmonzo in the Monzo app before the API will
+// give us anything beyond whoami.
+type approvalState string
+
+const (
+	approvalUnknown  approvalState = ""
+	approvalPending  approvalState = "pending"
+	approvalApproved approvalState = "approved"
+	approvalRevoked  approvalState = "revoked"
+)
+
+const (
+	tokenContextKey       = "token"
+	tokenSourceContextKey = "token_source"
+
+	// approvalPollSeconds is how often the status page asks the browser to
+	// check again while approval is pending.
+	approvalPollSeconds = "5"
+
+	// approvalRecheckInterval is how long an approval is trusted before Monzo
+	// is asked again, in case the user has taken access away in the app.
+	approvalRecheckInterval = 10 * time.Minute
+)
+
+// approvalTracker remembers each user's approval state. It lives in memory
+// only: after a restart the state is unknown until Monzo is asked again.
+type approvalTracker struct {
+	mu      sync.RWMutex
+	states  map[string]approvalState
+	checked map[string]time.Time
+	check   func(tokens monzo.TokenSource) (approvalState, error)
+	now     func() time.Time
+}
+
+func newApprovalTracker(check func(tokens monzo.TokenSource) (approvalState, error)) *approvalTracker {
+	return &approvalTracker{
+		states:  map[string]approvalState{},
+		checked: map[string]time.Time{},
+		check:   check,
+		now:     time.Now,
+	}
+}
+
+func (t *approvalTracker) Get(userID string) approvalState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.states[userID]
+}
+
+func (t *approvalTracker) Set(userID string, state approvalState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.states[userID] = state
+	t.checked[userID] = t.now()
+}
+
+// Forget drops what we know about the user, so that Monzo is asked again.
+func (t *approvalTracker) Forget(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.states, userID)
+	delete(t.checked, userID)
+}
+
+// Current returns the user's state, asking Monzo as tokens unless the user
+// approved us within the last approvalRecheckInterval.
+func (t *approvalTracker) Current(userID string, tokens monzo.TokenSource) (approvalState, error) {
+	t.mu.RLock()
+	state, checked := t.states[userID], t.checked[userID]
+	t.mu.RUnlock()
+	if state == approvalApproved && t.now().Sub(checked) < approvalRecheckInterval {
+		return state, nil
+	}
+
+	state, err := t.check(tokens)
+	if err != nil {
+		return approvalUnknown, err
+	}
+
+	t.Set(userID, state)
+	return state, nil
+}
+
+// checkApproval asks Monzo for the user's accounts, which is refused with a
+// 403 until the user approves us in the app and with a 401 once access has
+// been taken away. A refresh token Monzo won't take any more means the same.
+func checkApproval(ctx context.Context, api *monzo.Client, tokens monzo.TokenSource) (approvalState, error) {
+	_, err := api.WithTokens(tokens).Accounts(ctx, "")
+	if err == nil {
+		return approvalApproved, nil
+	}
+	if monzo.IsInvalidGrant(err) {
+		return approvalRevoked, nil
+	}
+
+	if apiErr, ok := err.(*monzo.APIError); ok {
+		switch apiErr.StatusCode {
+		case http.StatusForbidden:
+			return approvalPending, nil
+		case http.StatusUnauthorized:
+			return approvalRevoked, nil
+		}
+	}
+	return approvalUnknown, err
+}
+
+// authStatusHandlerWrapper reports the logged in user's approval state. While
+// approval is pending it asks the browser to refresh, so leaving the page
+// open polls Monzo until the user approves. onApproved is called once the
+// user has approved.
+func authStatusHandlerWrapper(tokens *tokenManager, approvals *approvalTracker, onApproved func(c *gin.Context, token AuthResponse)) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		token, ok := loadSessionToken(c, tokens)
+		if !ok {
+			return
+		}
+
+		state, err := approvals.Current(token.UserID, tokens.TokenSource(token.UserID))
+		if err != nil {
+			c.JSON(http.StatusBadGateway, err.Error())
+			return
+		}
+
+		switch state {
+		case approvalPending:
+			c.Header("Refresh", approvalPollSeconds)
+		case approvalApproved:
+			onApproved(c, token)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"approval": state,
+			"message":  approvalMessage(state),
+		})
+	}
+}
+
+// requireApprovalMiddleware guards API routes. It lets the request through
+// only for a logged in user who has approved askmonzo in the Monzo app, and
+// leaves the user's token and a source of fresh ones in the context. If
+// Monzo then turns the request down as unauthorised, the user's approval is
+// checked again next time.
+func requireApprovalMiddleware(tokens *tokenManager, approvals *approvalTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := loadSessionToken(c, tokens)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		source := tokens.TokenSource(token.UserID)
+		state, err := approvals.Current(token.UserID, source)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, err.Error())
+			c.Abort()
+			return
+		}
+
+		switch state {
+		case approvalApproved:
+			c.Set(tokenContextKey, token)
+			c.Set(tokenSourceContextKey, source)
+			c.Next()
+
+			for _, err := range c.Errors {
+				if monzo.IsInvalidGrant(err.Err) {
+					approvals.Set(token.UserID, approvalRevoked)
+				}
+				if apiErr, ok := err.Err.(*monzo.APIError); ok {
+					switch apiErr.StatusCode {
+					case http.StatusUnauthorized:
+						approvals.Set(token.UserID, approvalRevoked)
+					case http.StatusForbidden:
+						approvals.Forget(token.UserID)
+					}
+				}
+			}
+		case approvalPending:
+			c.JSON(http.StatusForbidden, gin.H{
+				"Error": approvalMessage(state),
+			})
+			c.Abort()
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"Error": approvalMessage(state),
+			})
+			c.Abort()
+		}
+	}
+}
+
+func approvalMessage(state approvalState) string {
+	switch state {
+	case approvalApproved:
+		return "access approved"
+	case approvalPending:
+		return "approve access in the Monzo app, then try again"
+	default:
+		return "access has been revoked, log in again"
+	}
+}
+
+// loadSessionToken finds the token of the user logged in to this session,
+// writing a 401 and returning false if there isn't one.
+func loadSessionToken(c *gin.Context, store TokenStore) (AuthResponse, bool) {
+	sess := getSession(c)
+
+	token, err := loadUserToken(store, sess)
+	if err == ErrTokenNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"Error": "Not logged in, visit /auth first",
+		})
+		return token, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return token, false
+	}
+	return token, true
+}
+
+// getToken returns the token left by requireApprovalMiddleware.
+func getToken(c *gin.Context) AuthResponse {
+	return c.MustGet(tokenContextKey).(AuthResponse)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovalTrackerStopsAskingOnceApproved(t *testing.T) {
+	checks := 0
+	approvals := newApprovalTracker(func(tokens monzo.TokenSource) (approvalState, error) {
+		checks++
+		if checks < 2 {
+			return approvalPending, nil
+		}
+		return approvalApproved, nil
+	})
+
+	token := AuthResponse{UserID: "alice", AccessToken: "access"}
+	for _, expected := range []approvalState{approvalPending, approvalApproved, approvalApproved} {
+		state, err := approvals.Current(token.UserID, monzo.StaticToken(token.AccessToken))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, state)
+	}
+	assert.Equal(t, 2, checks)
+}
+
+func TestApprovalTrackerRechecksApproval(t *testing.T) {
+	state := approvalApproved
+	checks := 0
+	approvals := newApprovalTracker(func(tokens monzo.TokenSource) (approvalState, error) {
+		checks++
+		return state, nil
+	})
+	now := time.Now()
+	approvals.now = func() time.Time { return now }
+
+	token := AuthResponse{UserID: "alice", AccessToken: "access"}
+	approvals.Current(token.UserID, monzo.StaticToken(token.AccessToken))
+	approvals.Current(token.UserID, monzo.StaticToken(token.AccessToken))
+	assert.Equal(t, 1, checks)
+
+	// Approval can be taken away in the app, so it is checked now and then
+	state = approvalRevoked
+	now = now.Add(approvalRecheckInterval)
+	current, err := approvals.Current(token.UserID, monzo.StaticToken(token.AccessToken))
+	assert.NoError(t, err)
+	assert.Equal(t, approvalRevoked, current)
+	assert.Equal(t, 2, checks)
+
+	// Forgetting a user asks Monzo again straight away
+	state = approvalApproved
+	approvals.Current(token.UserID, monzo.StaticToken(token.AccessToken))
+	approvals.Forget(token.UserID)
+	assert.Equal(t, approvalUnknown, approvals.Get(token.UserID))
+	approvals.Current(token.UserID, monzo.StaticToken(token.AccessToken))
+	assert.Equal(t, 4, checks)
+}
+
+func TestRequireApprovalMiddlewareNoticesRefusals(t *testing.T) {
+	store := newMemoryTokenStore()
+	store.Save("user_alice", AuthResponse{UserID: "user_alice", AccessToken: "access"})
+
+	checks := 0
+	approvals := newApprovalTracker(func(tokens monzo.TokenSource) (approvalState, error) {
+		checks++
+		return approvalApproved, nil
+	})
+
+	var refusal int
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		c.Set(sessionContextKey, &session{ID: "session", UserID: "user_alice"})
+	}, requireApprovalMiddleware(newTokenManager(store, nil), approvals), func(c *gin.Context) {
+		monzoErrorResponse(c, &monzo.APIError{StatusCode: refusal})
+	})
+
+	for _, test := range []struct {
+		refusal int
+		state   approvalState
+	}{
+		{http.StatusNotFound, approvalApproved},
+		{http.StatusForbidden, approvalUnknown},
+		{http.StatusUnauthorized, approvalRevoked},
+	} {
+		refusal = test.refusal
+		approvals.Set("user_alice", approvalApproved)
+
+		req, err := http.NewRequest("GET", "/", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, test.refusal, w.Code)
+		assert.Equal(t, test.state, approvals.Get("user_alice"), "After a %d", test.refusal)
+	}
+	assert.Equal(t, 0, checks)
+}
+
+func TestRequireApprovalMiddleware(t *testing.T) {
+	store := newMemoryTokenStore()
+	store.Save("user_alice", AuthResponse{UserID: "user_alice", AccessToken: "access"})
+
+	var state approvalState
+	approvals := newApprovalTracker(func(tokens monzo.TokenSource) (approvalState, error) {
+		return state, nil
+	})
+
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		c.Set(sessionContextKey, &session{ID: "session", UserID: c.Query("user")})
+	}, requireApprovalMiddleware(newTokenManager(store, nil), approvals), func(c *gin.Context) {
+		c.String(http.StatusOK, getToken(c).AccessToken)
+	})
+
+	for _, test := range []struct {
+		user  string
+		state approvalState
+		code  int
+	}{
+		{"", approvalApproved, http.StatusUnauthorized},
+		{"user_bob", approvalApproved, http.StatusUnauthorized},
+		{"user_alice", approvalPending, http.StatusForbidden},
+		{"user_alice", approvalRevoked, http.StatusUnauthorized},
+		{"user_alice", approvalApproved, http.StatusOK},
+	} {
+		state = test.state
+
+		req, err := http.NewRequest("GET", "/?user="+test.user, nil)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, test.code, w.Code, "User %q in state %q", test.user, test.state)
+	}
+}
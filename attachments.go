@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// maxAttachmentSize is the largest file, in bytes, we attach to a transaction.
+const maxAttachmentSize = 10 << 20
+
+// attachmentTypes are the kinds of file we attach, going by their content
+// rather than what the browser claims.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"application/pdf": true,
+}
+
+// uploadAttachmentHandlerWrapper attaches the file in the multipart form
+// field "file" to one of the user's transactions.
+func uploadAttachmentHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		// Leave room for the rest of the multipart body
+		limit := int64(maxAttachmentSize + 1<<20)
+		if c.Request.ContentLength > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"Error": "Files can be at most 10MB",
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "Send the file as multipart form data in a field called file, at most 10MB",
+			})
+			return
+		}
+		defer file.Close()
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(data) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "The file is empty",
+			})
+			return
+		}
+		if len(data) > maxAttachmentSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"Error": "Files can be at most 10MB",
+			})
+			return
+		}
+
+		fileType := http.DetectContentType(data)
+		if !attachmentTypes[fileType] {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"Error": "Only JPEG, PNG and GIF images and PDFs can be attached, not " + fileType,
+			})
+			return
+		}
+
+		attachment, err := userClient(c, api).AttachFile(c.Request.Context(), c.Param("id"), filepath.Base(header.Filename), fileType, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"attachment": attachment,
+		})
+	}
+}
+
+// attachmentsHandlerWrapper lists the attachments of one of the user's
+// transactions.
+func attachmentsHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		attachments, err := userClient(c, api).Attachments(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		if attachments == nil {
+			attachments = []monzo.Attachment{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"attachments": attachments,
+		})
+	}
+}
+
+// deregisterAttachmentHandlerWrapper removes an attachment from its
+// transaction.
+func deregisterAttachmentHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		err := userClient(c, api).DeregisterAttachment(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "attachment removed",
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader is enough of a PNG for its type to be recognised.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestAttachments(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+	fake.txMetadata["tx_1"] = map[string]string{}
+
+	browser := &fakeBrowser{server: testServer(fake.config())}
+	browser.login(t, "alice")
+
+	w := browser.upload(t, "/api/transactions/tx_1/attachments", "../receipt.png", pngHeader)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, pngHeader, fake.uploads["receipt.png"])
+
+	w = browser.get(t, "/api/transactions/tx_1/attachments")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Attachments []struct {
+			ID       string `json:"id"`
+			FileType string `json:"file_type"`
+		} `json:"attachments"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	if assert.Len(t, listed.Attachments, 1) {
+		assert.Equal(t, "image/png", listed.Attachments[0].FileType)
+	}
+
+	req, err := http.NewRequest("DELETE", "/api/attachments/"+listed.Attachments[0].ID, nil)
+	assert.NoError(t, err)
+	w = browser.do(t, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, fake.attachments["tx_1"])
+}
+
+func TestAttachmentUploadsAreValidated(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+	fake.txMetadata["tx_1"] = map[string]string{}
+
+	browser := &fakeBrowser{server: testServer(fake.config())}
+	browser.login(t, "alice")
+
+	w := browser.upload(t, "/api/transactions/tx_1/attachments", "receipt.png", []byte("#!/bin/sh\necho not an image\n"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = browser.upload(t, "/api/transactions/tx_1/attachments", "receipt.png", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	big := append(append([]byte{}, pngHeader...), make([]byte, maxAttachmentSize)...)
+	w = browser.upload(t, "/api/transactions/tx_1/attachments", "receipt.png", big)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req, err := http.NewRequest("POST", "/api/transactions/tx_1/attachments", bytes.NewReader(pngHeader))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "image/png")
+	w = browser.do(t, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Empty(t, fake.uploads)
+}
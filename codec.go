@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// minMasterKeyLength is the shortest master key we accept, in bytes.
+const minMasterKeyLength = 32
+
+// tokenCodec turns AuthResponses into the bytes a TokenStore writes to disk
+// and back. key is the record's key in the store.
+type tokenCodec interface {
+	Encode(key string, token AuthResponse) ([]byte, error)
+	Decode(key string, data []byte) (AuthResponse, error)
+}
+
+// plainCodec stores tokens as plain JSON.
+type plainCodec struct{}
+
+func (plainCodec) Encode(key string, token AuthResponse) ([]byte, error) {
+	return json.Marshal(token)
+}
+
+func (plainCodec) Decode(key string, data []byte) (AuthResponse, error) {
+	var token AuthResponse
+	err := json.Unmarshal(data, &token)
+	return token, err
+}
+
+// sealedRecord is a token encrypted by sealedCodec.
+type sealedRecord struct {
+	KeyVersion int    `json:"key_version"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// sealedCodec encrypts tokens with AES-GCM. It can decrypt with any of its
+// key versions but always encrypts with the current one, so saving a token
+// again moves it onto the current key. The record's store key is
+// authenticated along with it, so one user's record can't be passed off as
+// another's.
+type sealedCodec struct {
+	current int
+	aeads   map[int]cipher.AEAD
+}
+
+// newSealedCodec derives an AES-256 key from each master key, indexed by
+// key version.
+func newSealedCodec(masterKeys map[int]string, current int) (*sealedCodec, error) {
+	if _, ok := masterKeys[current]; !ok {
+		return nil, fmt.Errorf("no encryption key with version %d", current)
+	}
+
+	codec := &sealedCodec{
+		current: current,
+		aeads:   map[int]cipher.AEAD{},
+	}
+	for version, masterKey := range masterKeys {
+		if len(masterKey) < minMasterKeyLength {
+			return nil, fmt.Errorf("encryption key version %d is shorter than %d bytes", version, minMasterKeyLength)
+		}
+
+		block, err := aes.NewCipher(deriveTokenKey(masterKey))
+		if err != nil {
+			return nil, err
+		}
+		codec.aeads[version], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codec, nil
+}
+
+func deriveTokenKey(masterKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(masterKey))
+	mac.Write([]byte("askmonzo token encryption"))
+	return mac.Sum(nil)
+}
+
+func (s *sealedCodec) Encode(key string, token AuthResponse) ([]byte, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := s.aeads[s.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealedRecord{
+		KeyVersion: s.current,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(key)),
+	})
+}
+
+// Decode decrypts data. Records written before encryption was turned on are
+// still read as plain JSON, so that rotating keys can encrypt them.
+func (s *sealedCodec) Decode(key string, data []byte) (AuthResponse, error) {
+	var record sealedRecord
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	if record.Ciphertext == nil {
+		return plainCodec{}.Decode(key, data)
+	}
+
+	aead, ok := s.aeads[record.KeyVersion]
+	if !ok {
+		return AuthResponse{}, fmt.Errorf("token for %s is encrypted with unknown key version %d", key, record.KeyVersion)
+	}
+	if len(record.Nonce) != aead.NonceSize() {
+		return AuthResponse{}, fmt.Errorf("token for %s has a malformed nonce", key)
+	}
+
+	plaintext, err := aead.Open(nil, record.Nonce, record.Ciphertext, []byte(key))
+	if err != nil {
+		return AuthResponse{}, fmt.Errorf("failed to decrypt token for %s: %s", key, err)
+	}
+	return plainCodec{}.Decode(key, plaintext)
+}
+
+// rotateTokenKeys rewrites every token in store, which re-encrypts it with
+// the current key version. Tokens stay valid throughout, so nobody is logged
+// out. It returns how many tokens were rewritten.
+func rotateTokenKeys(store TokenStore) (int, error) {
+	keys, err := store.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, key := range keys {
+		token, err := store.Load(key)
+		if err == ErrTokenNotFound {
+			continue
+		}
+		if err != nil {
+			return rotated, err
+		}
+
+		err = store.Save(key, token)
+		if err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
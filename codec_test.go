@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKeyV1 = "0123456789abcdef0123456789abcdef"
+	testKeyV2 = "fedcba9876543210fedcba9876543210"
+)
+
+func TestSealedCodecRoundTrip(t *testing.T) {
+	codec, err := newSealedCodec(map[int]string{1: testKeyV1}, 1)
+	assert.NoError(t, err)
+
+	token := AuthResponse{AccessToken: "access-secret", RefreshToken: "refresh-secret", UserID: "user_alice"}
+	data, err := codec.Encode("user_alice", token)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "secret"), "Tokens should not be stored in plaintext")
+
+	decoded, err := codec.Decode("user_alice", data)
+	assert.NoError(t, err)
+	assert.Equal(t, token, decoded)
+
+	_, err = codec.Decode("user_mallory", data)
+	assert.Error(t, err, "A record should not decrypt under another user's key")
+}
+
+func TestSealedCodecReadsPlaintextRecords(t *testing.T) {
+	codec, err := newSealedCodec(map[int]string{1: testKeyV1}, 1)
+	assert.NoError(t, err)
+
+	decoded, err := codec.Decode("user_alice", []byte(`{"access_token":"old"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "old", decoded.AccessToken)
+}
+
+func TestSealedCodecRejectsShortKeys(t *testing.T) {
+	_, err := newSealedCodec(map[int]string{1: "short"}, 1)
+	assert.Error(t, err)
+
+	_, err = newSealedCodec(map[int]string{1: testKeyV1}, 2)
+	assert.Error(t, err)
+}
+
+func TestRotateTokenKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tokens.json")
+
+	oldStore, err := newTokenStore(StorageConfig{Type: "file", Path: path, EncryptionKeys: map[int]string{1: testKeyV1}})
+	assert.NoError(t, err)
+	assert.NoError(t, oldStore.Save("user_alice", AuthResponse{AccessToken: "alice"}))
+	assert.NoError(t, oldStore.Save("user_bob", AuthResponse{AccessToken: "bob"}))
+
+	// Both keys are configured while rotating, and the new one is current.
+	rotatingStore, err := newTokenStore(StorageConfig{Type: "file", Path: path, EncryptionKeys: map[int]string{1: testKeyV1, 2: testKeyV2}})
+	assert.NoError(t, err)
+	tokens := newTokenManager(rotatingStore, nil)
+
+	rotated, err := tokens.RotateKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rotated)
+
+	// Once rotated, the old key can be dropped.
+	newStore, err := newTokenStore(StorageConfig{Type: "file", Path: path, EncryptionKeys: map[int]string{2: testKeyV2}})
+	assert.NoError(t, err)
+
+	token, err := newStore.Load("user_alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", token.AccessToken)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	defaultPort    = "8080"
+	defaultAuthURL = "https://auth.getmondo.co.uk"
+	defaultAPIURL  = "https://api.monzo.com"
+
+	defaultRateLimit = 5
+
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerMaxCacheAge      = 15 * time.Minute
+)
+
+// Config is everything the server needs to run. It is read from an optional
+// YAML file and then from environment variables, which win.
+type Config struct {
+	Port string `yaml:"port"`
+
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+
+	// AuthURL is where users are sent to log in.
+	AuthURL string `yaml:"auth_url"`
+	// APIURL is the base of the Monzo API, token endpoint included.
+	APIURL string `yaml:"api_url"`
+	// PublicURL, if set, is the scheme and host users reach us on, such as
+	// https://askmonzo.example.com. It is used to build redirect_uri and
+	// webhook URLs.
+	PublicURL string `yaml:"public_url"`
+	// TrustProxyHeaders takes the scheme and host from X-Forwarded-Proto and
+	// X-Forwarded-Host when there is no PublicURL. Only turn it on behind a
+	// proxy that sets them.
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers"`
+	// FeedImageURL is the image shown on items we put in users' feeds.
+	// Monzo requires one, so nothing is posted to feeds without it.
+	FeedImageURL string `yaml:"feed_image_url"`
+
+	SessionSecret string `yaml:"session_secret"`
+	// WebhookSecret signs the secret in our webhook URLs, SessionSecret
+	// being used when it is empty. Webhooks are only registered when one
+	// of them is set.
+	WebhookSecret string        `yaml:"webhook_secret"`
+	AdminToken    string        `yaml:"admin_token"`
+	UsePKCE       bool          `yaml:"use_pkce"`
+	RefreshMargin time.Duration `yaml:"refresh_margin"`
+	// RateLimit is how many requests a second we make to Monzo for each
+	// user, with short bursts of twice as many allowed.
+	RateLimit float64 `yaml:"rate_limit"`
+	// BreakerFailureThreshold is how many Monzo calls in a row have to fail
+	// before we stop calling it for BreakerOpenTimeout.
+	BreakerFailureThreshold int           `yaml:"breaker_failure_threshold"`
+	BreakerOpenTimeout      time.Duration `yaml:"breaker_open_timeout"`
+	// BreakerMaxCacheAge is how long responses are kept for serving while
+	// the breaker is open.
+	BreakerMaxCacheAge time.Duration `yaml:"breaker_max_cache_age"`
+
+	Storage StorageConfig `yaml:"storage"`
+
+	// monzoTransport, if set, is what calls to Monzo go out through once
+	// rate limited, so that tests can replay recorded fixtures.
+	monzoTransport http.RoundTripper
+}
+
+// StorageConfig picks the TokenStore, see newTokenStore.
+type StorageConfig struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+
+	// EncryptionKeys are master keys for encrypting tokens at rest, by
+	// version. Keep old versions around until rotate-keys has been run.
+	EncryptionKeys map[int]string `yaml:"encryption_keys"`
+	// EncryptionKeyVersion is the key new tokens are encrypted with. It
+	// defaults to the highest version.
+	EncryptionKeyVersion int `yaml:"encryption_key_version"`
+}
+
+// ConfigError lists everything wrong with a configuration.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:                    defaultPort,
+		AuthURL:                 defaultAuthURL,
+		APIURL:                  defaultAPIURL,
+		RefreshMargin:           defaultRefreshMargin,
+		RateLimit:               defaultRateLimit,
+		BreakerFailureThreshold: defaultBreakerFailureThreshold,
+		BreakerOpenTimeout:      defaultBreakerOpenTimeout,
+		BreakerMaxCacheAge:      defaultBreakerMaxCacheAge,
+		Storage:                 StorageConfig{Type: "memory"},
+	}
+}
+
+// loadConfig reads the YAML file at path, if path is not empty, then applies
+// the environment as seen through getenv and validates the result.
+func loadConfig(path string, getenv func(string) string) (Config, error) {
+	cfg := defaultConfig()
+	var problems []string
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = yaml.Unmarshal(data, &cfg)
+		}
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, e := range typeErr.Errors {
+				problems = append(problems, fmt.Sprintf("%s: %s", path, e))
+			}
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", path, err))
+		}
+	}
+
+	problems = append(problems, cfg.applyEnv(getenv)...)
+	problems = append(problems, cfg.validate()...)
+
+	if len(problems) > 0 {
+		return cfg, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides cfg with any environment variables that are set.
+func (cfg *Config) applyEnv(getenv func(string) string) []string {
+	var problems []string
+
+	fields := map[string]*string{
+		"PORT":             &cfg.Port,
+		"CLIENT_ID":        &cfg.ClientID,
+		"CLIENT_SECRET":    &cfg.ClientSecret,
+		"MONZO_AUTH_URL":   &cfg.AuthURL,
+		"MONZO_API_URL":    &cfg.APIURL,
+		"PUBLIC_URL":       &cfg.PublicURL,
+		"FEED_IMAGE_URL":   &cfg.FeedImageURL,
+		"SESSION_SECRET":   &cfg.SessionSecret,
+		"WEBHOOK_SECRET":   &cfg.WebhookSecret,
+		"ADMIN_TOKEN":      &cfg.AdminToken,
+		"TOKEN_STORE":      &cfg.Storage.Type,
+		"TOKEN_STORE_PATH": &cfg.Storage.Path,
+	}
+	for name, field := range fields {
+		if v := getenv(name); v != "" {
+			*field = v
+		}
+	}
+
+	if v := getenv("USE_PKCE"); v != "" {
+		usePKCE, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("USE_PKCE must be true or false, got %q", v))
+		}
+		cfg.UsePKCE = usePKCE
+	}
+
+	if v := getenv("TRUST_PROXY_HEADERS"); v != "" {
+		trust, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("TRUST_PROXY_HEADERS must be true or false, got %q", v))
+		}
+		cfg.TrustProxyHeaders = trust
+	}
+
+	if v := getenv("TOKEN_ENCRYPTION_KEYS"); v != "" {
+		keys, err := parseEncryptionKeys(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("TOKEN_ENCRYPTION_KEYS %s", err))
+		}
+		cfg.Storage.EncryptionKeys = keys
+	}
+
+	if v := getenv("TOKEN_ENCRYPTION_KEY_VERSION"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("TOKEN_ENCRYPTION_KEY_VERSION must be a number, got %q", v))
+		}
+		cfg.Storage.EncryptionKeyVersion = version
+	}
+
+	if v := getenv("RATE_LIMIT"); v != "" {
+		rateLimit, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("RATE_LIMIT must be a number of requests a second, got %q", v))
+		}
+		cfg.RateLimit = rateLimit
+	}
+
+	if v := getenv("BREAKER_FAILURE_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("BREAKER_FAILURE_THRESHOLD must be a number, got %q", v))
+		}
+		cfg.BreakerFailureThreshold = threshold
+	}
+
+	if v := getenv("BREAKER_OPEN_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("BREAKER_OPEN_TIMEOUT must be a duration such as 30s, got %q", v))
+		}
+		cfg.BreakerOpenTimeout = timeout
+	}
+
+	if v := getenv("BREAKER_MAX_CACHE_AGE"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("BREAKER_MAX_CACHE_AGE must be a duration such as 15m, got %q", v))
+		}
+		cfg.BreakerMaxCacheAge = age
+	}
+
+	if v := getenv("REFRESH_MARGIN"); v != "" {
+		margin, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("REFRESH_MARGIN must be a duration such as 5m, got %q", v))
+		}
+		cfg.RefreshMargin = margin
+	}
+
+	return problems
+}
+
+func (cfg *Config) validate() []string {
+	var problems []string
+
+	if cfg.ClientID == "" {
+		problems = append(problems, "no client ID, set CLIENT_ID or client_id")
+	}
+	if cfg.ClientSecret == "" {
+		problems = append(problems, "no client secret, set CLIENT_SECRET or client_secret")
+	}
+	if _, err := strconv.ParseUint(cfg.Port, 10, 16); err != nil {
+		problems = append(problems, fmt.Sprintf("port must be a number, got %q", cfg.Port))
+	}
+
+	if !isAbsoluteURL(cfg.AuthURL) {
+		problems = append(problems, fmt.Sprintf("auth URL must be an absolute URL, got %q", cfg.AuthURL))
+	}
+	if !isAbsoluteURL(cfg.APIURL) {
+		problems = append(problems, fmt.Sprintf("API URL must be an absolute URL, got %q", cfg.APIURL))
+	}
+
+	if cfg.PublicURL != "" && !isAbsoluteURL(cfg.PublicURL) {
+		problems = append(problems, fmt.Sprintf("public URL must be an absolute URL, got %q", cfg.PublicURL))
+	}
+
+	if cfg.FeedImageURL != "" && !isAbsoluteURL(cfg.FeedImageURL) {
+		problems = append(problems, fmt.Sprintf("feed image URL must be an absolute URL, got %q", cfg.FeedImageURL))
+	}
+
+	if cfg.RateLimit <= 0 {
+		problems = append(problems, "rate limit must be more than 0 requests a second")
+	}
+
+	if cfg.BreakerFailureThreshold <= 0 {
+		problems = append(problems, "breaker failure threshold must be at least 1")
+	}
+	if cfg.BreakerOpenTimeout <= 0 {
+		problems = append(problems, "breaker open timeout must be more than 0")
+	}
+	if cfg.BreakerMaxCacheAge <= 0 {
+		problems = append(problems, "breaker max cache age must be more than 0")
+	}
+
+	if cfg.RefreshMargin < 0 {
+		problems = append(problems, "refresh margin can't be negative")
+	}
+
+	switch cfg.Storage.Type {
+	case "", "memory", "file", "bolt":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown token store %q, use memory, file or bolt", cfg.Storage.Type))
+	}
+
+	if len(cfg.Storage.EncryptionKeys) > 0 {
+		current := cfg.Storage.currentKeyVersion()
+		if _, ok := cfg.Storage.EncryptionKeys[current]; !ok {
+			problems = append(problems, fmt.Sprintf("no encryption key with version %d", current))
+		}
+		for version, key := range cfg.Storage.EncryptionKeys {
+			if len(key) < minMasterKeyLength {
+				problems = append(problems, fmt.Sprintf("encryption key version %d must be at least %d characters", version, minMasterKeyLength))
+			}
+		}
+	}
+
+	return problems
+}
+
+// currentKeyVersion is EncryptionKeyVersion, or the highest key version if
+// that isn't set.
+func (cfg StorageConfig) currentKeyVersion() int {
+	current := cfg.EncryptionKeyVersion
+	if current == 0 {
+		for version := range cfg.EncryptionKeys {
+			if version > current {
+				current = version
+			}
+		}
+	}
+	return current
+}
+
+// parseEncryptionKeys reads keys written as version:key pairs separated by
+// commas, such as "1:first-key,2:second-key".
+func parseEncryptionKeys(raw string) (map[int]string, error) {
+	keys := map[int]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("must be version:key pairs separated by commas")
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("has a bad key version %q", parts[0])
+		}
+		keys[version] = parts[1]
+	}
+	return keys, nil
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeEnv(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	cfg, err := loadConfig("", fakeEnv(map[string]string{
+		"CLIENT_ID":      "client",
+		"CLIENT_SECRET":  "secret",
+		"USE_PKCE":       "true",
+		"REFRESH_MARGIN": "10m",
+		"TOKEN_STORE":    "bolt",
+		"RATE_LIMIT":     "2.5",
+
+		"BREAKER_FAILURE_THRESHOLD": "3",
+		"BREAKER_OPEN_TIMEOUT":      "1m",
+		"BREAKER_MAX_CACHE_AGE":     "5m",
+	}))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "client", cfg.ClientID)
+	assert.Equal(t, "secret", cfg.ClientSecret)
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, defaultAPIURL, cfg.APIURL)
+	assert.True(t, cfg.UsePKCE)
+	assert.Equal(t, 10*time.Minute, cfg.RefreshMargin)
+	assert.Equal(t, "bolt", cfg.Storage.Type)
+	assert.Equal(t, 2.5, cfg.RateLimit)
+	assert.Equal(t, 3, cfg.BreakerFailureThreshold)
+	assert.Equal(t, time.Minute, cfg.BreakerOpenTimeout)
+	assert.Equal(t, 5*time.Minute, cfg.BreakerMaxCacheAge)
+}
+
+func TestLoadConfigFromYAML(t *testing.T) {
+	f, err := ioutil.TempFile("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`
+client_id: from-file
+client_secret: secret
+api_url: http://localhost:9000
+refresh_margin: 2m
+storage:
+  type: file
+  path: /tmp/tokens.json
+`)
+	f.Close()
+
+	cfg, err := loadConfig(f.Name(), fakeEnv(map[string]string{"CLIENT_ID": "from-env"}))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "from-env", cfg.ClientID, "The environment should win over the file")
+	assert.Equal(t, "secret", cfg.ClientSecret)
+	assert.Equal(t, "http://localhost:9000", cfg.APIURL)
+	assert.Equal(t, 2*time.Minute, cfg.RefreshMargin)
+	assert.Equal(t, StorageConfig{Type: "file", Path: "/tmp/tokens.json"}, cfg.Storage)
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	_, err := loadConfig("", fakeEnv(map[string]string{
+		"PORT":          "http",
+		"USE_PKCE":      "maybe",
+		"MONZO_API_URL": "api.monzo.com",
+		"TOKEN_STORE":   "redis",
+	}))
+
+	configErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("Expected a *ConfigError, got %v", err)
+	}
+	assert.Len(t, configErr.Problems, 6)
+}
+
+func TestLoadConfigReportsMissingFile(t *testing.T) {
+	_, err := loadConfig("does-not-exist.yml", fakeEnv(map[string]string{
+		"CLIENT_ID":     "client",
+		"CLIENT_SECRET": "secret",
+	}))
+	assert.Error(t, err)
+}
+
+func TestLoadConfigEncryptionKeys(t *testing.T) {
+	cfg, err := loadConfig("", fakeEnv(map[string]string{
+		"CLIENT_ID":             "client",
+		"CLIENT_SECRET":         "secret",
+		"TOKEN_ENCRYPTION_KEYS": "1:" + testKeyV1 + ", 2:" + testKeyV2,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{1: testKeyV1, 2: testKeyV2}, cfg.Storage.EncryptionKeys)
+	assert.Equal(t, 2, cfg.Storage.currentKeyVersion())
+
+	_, err = loadConfig("", fakeEnv(map[string]string{
+		"CLIENT_ID":                    "client",
+		"CLIENT_SECRET":                "secret",
+		"TOKEN_ENCRYPTION_KEYS":        "1:too-short",
+		"TOKEN_ENCRYPTION_KEY_VERSION": "3",
+	}))
+	configErr, ok := err.(*ConfigError)
+	if assert.True(t, ok) {
+		assert.Len(t, configErr.Problems, 2)
+	}
+}
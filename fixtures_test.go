@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+var recordFixtures = flag.Bool("record", false, "record testdata fixtures against Monzo instead of replaying them")
+
+// The made up client, user and account fixtures are recorded with, and the
+// authorization code the replayed login uses.
+const (
+	fixtureClientID  = "client"
+	fixtureUserID    = "user_00009fixtureUser0001"
+	fixtureAccountID = "acc_00009fixtureAccount01"
+	fixtureAuthCode  = "code"
+)
+
+// fixtureConfig returns a config whose calls to Monzo replay
+// testdata/<name>.json.
+//
+// With -record they go to MONZO_API_URL, or Monzo itself, and the fixture is
+// rewritten once the test is done. Logging in needs MONZO_CLIENT_ID and
+// MONZO_CLIENT_SECRET for a client that redirects to
+// https://askmonzo.example.com/auth/callback, and a fresh MONZO_AUTH_CODE.
+// MONZO_USER_ID and MONZO_ACCOUNT_ID are swapped for the made up IDs above.
+func fixtureConfig(t *testing.T, name string) (Config, func()) {
+	path := filepath.Join("testdata", name+".json")
+
+	cfg := testConfig()
+	cfg.PublicURL = "https://askmonzo.example.com"
+	cfg.SessionSecret = "fixture session secret"
+
+	if !*recordFixtures {
+		replayer, err := monzo.LoadReplayer(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg.monzoTransport = replayer
+		return cfg, func() {
+			assert.Empty(t, replayer.Unreplayed(), "Every recorded request should be made")
+		}
+	}
+
+	cfg.ClientID = os.Getenv("MONZO_CLIENT_ID")
+	cfg.ClientSecret = os.Getenv("MONZO_CLIENT_SECRET")
+	if url := os.Getenv("MONZO_API_URL"); url != "" {
+		cfg.APIURL = url
+	}
+
+	// Webhook URLs carry a secret made from the user ID
+	webhooks := newWebhookManager(nil, []byte(cfg.SessionSecret))
+	userID := os.Getenv("MONZO_USER_ID")
+
+	recorder := monzo.NewRecorder(http.DefaultTransport)
+	recorder.Scrubber.Replace[cfg.ClientID] = fixtureClientID
+	recorder.Scrubber.Replace[userID] = fixtureUserID
+	recorder.Scrubber.Replace[webhooks.userSecret(userID)] = webhooks.userSecret(fixtureUserID)
+	recorder.Scrubber.Replace[os.Getenv("MONZO_ACCOUNT_ID")] = fixtureAccountID
+
+	cfg.monzoTransport = recorder
+	return cfg, func() {
+		assert.NoError(t, recorder.Save(path))
+	}
+}
+
+// fixtureLogin logs in as the user fixtures are recorded with.
+func fixtureLogin(t *testing.T, browser *fakeBrowser) {
+	code := fixtureAuthCode
+	if *recordFixtures {
+		code = os.Getenv("MONZO_AUTH_CODE")
+	}
+
+	w := browser.login(t, code)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to log in, returned %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// fixtureAccount is the account fixtures are recorded against.
+func fixtureAccount() string {
+	if *recordFixtures {
+		return os.Getenv("MONZO_ACCOUNT_ID")
+	}
+	return fixtureAccountID
+}
+
+func TestFixtureCallback(t *testing.T) {
+	cfg, done := fixtureConfig(t, "callback")
+	defer done()
+
+	browser := &fakeBrowser{server: testServer(cfg)}
+	fixtureLogin(t, browser)
+
+	w := browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), string(approvalApproved)), "Got %s", w.Body.String())
+}
+
+func TestFixtureAccountsAndTransactions(t *testing.T) {
+	cfg, done := fixtureConfig(t, "transactions")
+	defer done()
+
+	browser := &fakeBrowser{server: testServer(cfg)}
+	fixtureLogin(t, browser)
+
+	w := browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var accounts struct {
+		Accounts []monzo.Account `json:"accounts"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &accounts))
+	if assert.Len(t, accounts.Accounts, 1) {
+		assert.Equal(t, fixtureAccount(), accounts.Accounts[0].ID)
+	}
+
+	w = browser.get(t, "/api/accounts/"+fixtureAccount()+"/balance")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"balance":12345,"total_balance":45678,"currency":"GBP","spend_today":-1520}`, w.Body.String())
+
+	w = browser.get(t, "/api/transactions?account_id="+fixtureAccount()+"&since=2018-01-01&before=2018-01-08&expand=merchant")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var transactions struct {
+		Transactions []monzo.Transaction `json:"transactions"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &transactions))
+	if assert.Len(t, transactions.Transactions, 5) {
+		assert.Equal(t, "tx_00009fixtureTx0000001", transactions.Transactions[0].ID)
+		assert.Equal(t, "Pret A Manger", transactions.Transactions[1].Merchant.Name)
+	}
+}
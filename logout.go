@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// logoutHandlerWrapper revokes the current user's token at Monzo, forgets it,
+// their approval, their webhooks and their cached data, and clears the
+// session. Copies of the
+// session cookie stop working too, since they were issued before the user's
+// next login.
+func logoutHandlerWrapper(api *monzo.Client, breaker *monzo.Breaker, store TokenStore, approvals *approvalTracker, webhooks *webhookManager, sessions *sessionManager) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		sess := getSession(c)
+
+		// A session from before the user last logged out can't log them out
+		_, err := loadUserToken(store, sess)
+		if err == nil {
+			_, err = logoutUser(c.Request.Context(), api, breaker, store, webhooks, sess.UserID)
+			if err == nil {
+				approvals.Forget(sess.UserID)
+			}
+		}
+		if err != nil && err != ErrTokenNotFound {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		sessions.clear(c)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "logged out",
+		})
+	}
+}
+
+// adminLogoutAllHandlerWrapper revokes and forgets every stored token.
+func adminLogoutAllHandlerWrapper(api *monzo.Client, breaker *monzo.Breaker, store TokenStore, approvals *approvalTracker, webhooks *webhookManager) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		userIDs, err := store.Keys()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		failed := []string{}
+		notRevoked := []string{}
+		for _, userID := range userIDs {
+			revoked, err := logoutUser(c.Request.Context(), api, breaker, store, webhooks, userID)
+			if err != nil {
+				fmt.Printf("Failed to log out %s: %s\n", userID, err)
+				failed = append(failed, userID)
+				continue
+			}
+			approvals.Forget(userID)
+			if !revoked {
+				notRevoked = append(notRevoked, userID)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"logged_out":  len(userIDs) - len(failed),
+			"failed":      failed,
+			"not_revoked": notRevoked,
+		})
+	}
+}
+
+// adminRotateKeysHandlerWrapper re-encrypts every stored token with the
+// current encryption key, after which older keys can be retired.
+func adminRotateKeysHandlerWrapper(tokens *tokenManager) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		rotated, err := tokens.RotateKeys()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"Error":   err.Error(),
+				"rotated": rotated,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"rotated": rotated,
+		})
+	}
+}
+
+// adminMiddleware lets a request through only if it carries the admin token
+// as a bearer token.
+func adminMiddleware(adminToken string) gin.HandlerFunc {
+	expected := []byte("Bearer " + adminToken)
+
+	return func(c *gin.Context) {
+		given := []byte(c.Request.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, expected) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// logoutUser deletes userID's webhooks, revokes their token at Monzo, drops
+// whatever breaker has cached for it and removes it from store, reporting
+// whether Monzo accepted the revocation. The
+// token is removed even if Monzo can't be reached, since it is no use to us
+// once the user has asked to log out.
+func logoutUser(ctx context.Context, api *monzo.Client, breaker *monzo.Breaker, store TokenStore, webhooks *webhookManager, userID string) (revoked bool, err error) {
+	token, err := store.Load(userID)
+	if err == ErrTokenNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = webhooks.Unregister(ctx, token)
+	if err != nil {
+		fmt.Printf("Failed to delete webhooks for %s: %s\n", userID, err)
+	}
+
+	err = api.WithTokens(monzo.StaticToken(token.AccessToken)).Logout(ctx)
+	if err != nil {
+		fmt.Printf("Failed to revoke token for %s: %s\n", userID, err)
+	}
+	revoked = err == nil
+	breaker.Forget(token.AccessToken)
+
+	return revoked, store.Delete(userID)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogoutWithoutLogin(t *testing.T) {
+	server := testServer(testConfig())
+
+	req, err := http.NewRequest("POST", "/auth/logout", nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cookies := w.Result().Cookies()
+	if assert.NotEmpty(t, cookies) {
+		last := cookies[len(cookies)-1]
+		assert.Equal(t, sessionCookieName, last.Name)
+		assert.True(t, last.MaxAge < 0, "Logout should expire the session cookie")
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	router := gin.New()
+	router.GET("/", adminMiddleware("letmein"), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for header, code := range map[string]int{
+		"":                 http.StatusUnauthorized,
+		"Bearer wrong":     http.StatusUnauthorized,
+		"letmein":          http.StatusUnauthorized,
+		"Bearer letmein":   http.StatusOK,
+		"Bearer letmein ":  http.StatusUnauthorized,
+		"Bearer letmeinxx": http.StatusUnauthorized,
+	} {
+		req, err := http.NewRequest("GET", "/", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", header)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, code, w.Code, "Authorization %q", header)
+	}
+}
+
+// fileStoreConfig points monzo.config() at a token file in dir, so that tests
+// can look at what the server left behind.
+func fileStoreConfig(monzo *fakeMonzo, dir string, keys map[int]string) Config {
+	cfg := monzo.config()
+	cfg.AdminToken = "letmein"
+	cfg.Storage = StorageConfig{Type: "file", Path: filepath.Join(dir, "tokens.json"), EncryptionKeys: keys}
+	return cfg
+}
+
+func storedUsers(t *testing.T, cfg Config) []string {
+	store, err := newTokenStore(cfg.Storage)
+	assert.NoError(t, err)
+	keys, err := store.Keys()
+	assert.NoError(t, err)
+	return keys
+}
+
+func adminPost(t *testing.T, server http.Handler, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", path, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer letmein")
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestLogout(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := fileStoreConfig(monzo, dir, map[int]string{1: testKeyV1})
+	cfg.SessionSecret = "secret"
+	browser := &fakeBrowser{server: testServer(cfg)}
+	browser.login(t, "alice")
+	assert.Equal(t, []string{"user_alice"}, storedUsers(t, cfg))
+	copied := &fakeBrowser{server: browser.server, cookies: map[string]*http.Cookie{}}
+	for name, cookie := range browser.cookies {
+		copied.cookies[name] = cookie
+	}
+
+	w := browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = browser.get(t, "/health")
+	assert.NotContains(t, w.Body.String(), `"cached_responses":0`)
+
+	w = browser.post(t, "/auth/logout", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Bearer access-alice"}, monzo.logoutTokens)
+	assert.Empty(t, storedUsers(t, cfg))
+
+	// Nothing of alice's is kept for when Monzo is down
+	w = browser.get(t, "/health")
+	assert.Contains(t, w.Body.String(), `"cached_responses":0`)
+
+	// The session no longer belongs to anyone
+	w = browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = browser.get(t, "/api/accounts")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Nor does a copy of it, even once alice logs in again, on a server
+	// that has restarted since
+	browser = &fakeBrowser{server: testServer(cfg)}
+	browser.login(t, "alice")
+	copied.server = browser.server
+	w = copied.get(t, "/auth/status")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = copied.post(t, "/auth/logout", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"user_alice"}, storedUsers(t, cfg))
+	w = browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoginFromAnotherBrowserKeepsSessions(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	server := testServer(monzo.config())
+	laptop := &fakeBrowser{server: server}
+	laptop.login(t, "alice")
+	phone := &fakeBrowser{server: server}
+	phone.login(t, "alice")
+
+	w := laptop.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = phone.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminLogoutAll(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := fileStoreConfig(monzo, dir, nil)
+	server := testServer(cfg)
+	(&fakeBrowser{server: server}).login(t, "alice")
+	(&fakeBrowser{server: server}).login(t, "bob")
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/logout-all", nil)
+	assert.NoError(t, err)
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Len(t, storedUsers(t, cfg), 2)
+
+	w = adminPost(t, server, "/admin/logout-all")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"logged_out":2,"failed":[],"not_revoked":[]}`, w.Body.String())
+	assert.ElementsMatch(t, []string{"Bearer access-alice", "Bearer access-bob"}, monzo.logoutTokens)
+	assert.Empty(t, storedUsers(t, cfg))
+}
+
+func TestAdminRotateKeys(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := fileStoreConfig(monzo, dir, map[int]string{1: testKeyV1})
+	(&fakeBrowser{server: testServer(cfg)}).login(t, "alice")
+
+	cfg = fileStoreConfig(monzo, dir, map[int]string{1: testKeyV1, 2: testKeyV2})
+	w := adminPost(t, testServer(cfg), "/admin/rotate-keys")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rotated struct {
+		Rotated int `json:"rotated"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	assert.Equal(t, 1, rotated.Rotated)
+
+	// The old key can go now
+	store, err := newTokenStore(fileStoreConfig(monzo, dir, map[int]string{2: testKeyV2}).Storage)
+	assert.NoError(t, err)
+	token, err := store.Load("user_alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "access-alice", token.AccessToken)
+}
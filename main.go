@@ -1,24 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
 )
 
-var state string
-var expiryTime time.Time
-var authResponse AuthResponse
-
 type AuthResponse struct {
 	AccessToken         string `json:"access_token"`
 	ClientID            string `json:"client_id"`
@@ -27,35 +21,133 @@ type AuthResponse struct {
 	TokenType           string `json:"token_type"`
 	UserID              string `json:"user_id"`
 	AuthExpiryTimestamp int64
-}
-
-type BearerResponse struct {
-	Authenticated bool   `json:"authenticated"`
-	ClientID      string `json:"client_id"`
-	UserID        string `json:"user_id"`
+	// SessionsSince is when the user logged in after last being logged out.
+	// Sessions from before then are not theirs any more.
+	SessionsSince time.Time
 }
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	cfg, err := loadConfig(os.Getenv("CONFIG_FILE"), os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	newServer().Run(":" + port)
+	server, refresher := newServer(cfg)
+	go refresher.Run(nil)
+	server.Run(":" + cfg.Port)
 }
 
-func newServer() *gin.Engine {
+// newServer sets up every route, and the refresher that keeps the stored
+// tokens fresh for the caller to run for as long as it serves.
+func newServer(cfg Config) (*gin.Engine, *tokenRefresher) {
 	router := gin.Default()
 
-	// Set the environment variables
-	clientID := getEnv("CLIENT_ID")
-	clientSecret := getEnv("CLIENT_SECRET")
+	store, err := newTokenStore(cfg.Storage)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to open the token store: %s", err))
+	}
+
+	sessionSecret := cfg.SessionSecret
+	if sessionSecret == "" {
+		fmt.Printf("No session secret configured, sessions will not survive a restart\n")
+		sessionSecret = randomToken()
+	}
+	sessions := newSessionManager([]byte(sessionSecret), func(c *gin.Context) bool {
+		return strings.HasPrefix(publicBaseURL(c, cfg), "https://")
+	})
+	api := monzo.NewClient(cfg.APIURL, nil)
+	transport := monzo.NewTransport()
+	transport.RequestsPerSecond = cfg.RateLimit
+	transport.Burst = int(2 * cfg.RateLimit)
+	if cfg.monzoTransport != nil {
+		transport.Base = cfg.monzoTransport
+	}
+	breaker := monzo.NewBreaker(transport)
+	breaker.FailureThreshold = cfg.BreakerFailureThreshold
+	breaker.OpenTimeout = cfg.BreakerOpenTimeout
+	breaker.MaxCacheAge = cfg.BreakerMaxCacheAge
+	api.HTTPClient = monzo.NewHTTPClient(breaker)
+	states := newStateManager(stateTTL, cfg.UsePKCE)
+	approvals := newApprovalTracker(func(tokens monzo.TokenSource) (approvalState, error) {
+		return checkApproval(context.Background(), api, tokens)
+	})
+
+	tokens := newTokenManager(store, func(token AuthResponse) (AuthResponse, error) {
+		return refreshAuthenticationToken(context.Background(), api, cfg, store, token.RefreshToken)
+	})
+	refresher := newTokenRefresher(tokens, cfg.RefreshMargin, func(token AuthResponse) (AuthResponse, error) {
+		refreshed, err := tokens.Refresh(token)
+		if monzo.IsInvalidGrant(err) {
+			approvals.Forget(token.UserID)
+		}
+		return refreshed, err
+	})
+
+	notifier, err := newFeedNotifier(api, tokens, cfg.FeedImageURL, notificationTemplates)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse the notification templates: %s", err))
+	}
+
+	// Webhook URLs carry a secret signed with this, so a random one would
+	// leave Monzo posting to URLs we stop accepting after a restart
+	webhookSecret := cfg.WebhookSecret
+	if webhookSecret == "" {
+		webhookSecret = cfg.SessionSecret
+	}
+	if webhookSecret == "" {
+		fmt.Printf("No webhook secret configured, set WEBHOOK_SECRET or SESSION_SECRET to receive webhooks\n")
+	}
+	webhooks := newWebhookManager(api, []byte(webhookSecret))
+	webhooks.OnTransactionCreated(logTransaction)
+	// Webhooks can only be registered once the user has approved us
+	registerWebhooks := func(c *gin.Context, token AuthResponse) {
+		if webhookSecret == "" {
+			return
+		}
+		err := webhooks.Register(c.Request.Context(), token, publicBaseURL(c, cfg))
+		if err != nil {
+			fmt.Printf("Failed to register webhooks for %s: %s\n", token.UserID, err)
+		}
+	}
 
 	router.GET("/ping", pingHandler)
-	router.GET("/auth", authHandlerWrapper(clientID))
-	router.GET("/auth/callback", setAuthCallbackEndpointWrapper(clientID, clientSecret))
+	router.GET("/health", healthHandlerWrapper(breaker))
+	if webhookSecret != "" {
+		router.POST(webhookPath+":secret", webhooks.receiveHandler)
+	}
+
+	auth := router.Group("/auth", sessions.middleware())
+	auth.GET("", authHandlerWrapper(cfg, states))
+	auth.GET("/callback", setAuthCallbackEndpointWrapper(cfg, api, tokens, sessions, states, approvals, registerWebhooks))
+	auth.GET("/status", authStatusHandlerWrapper(tokens, approvals, registerWebhooks))
+	auth.POST("/logout", logoutHandlerWrapper(api, breaker, tokens, approvals, webhooks, sessions))
+
+	// Admin routes are only served when an admin token has been set
+	if cfg.AdminToken != "" {
+		admin := router.Group("/admin", adminMiddleware(cfg.AdminToken))
+		admin.POST("/logout-all", adminLogoutAllHandlerWrapper(api, breaker, tokens, approvals, webhooks))
+		admin.POST("/rotate-keys", adminRotateKeysHandlerWrapper(tokens))
+	}
 
-	return router
+	// API routes answer for the logged in user, once they have approved us
+	apiRoutes := router.Group("/api", sessions.middleware(), requireApprovalMiddleware(tokens, approvals))
+	apiRoutes.GET("/accounts", accountsHandlerWrapper(api))
+	apiRoutes.GET("/accounts/:id/balance", balanceHandlerWrapper(api))
+	apiRoutes.GET("/transactions", transactionsHandlerWrapper(api))
+	apiRoutes.PATCH("/transactions/:id/notes", annotateTransactionHandlerWrapper(api))
+	apiRoutes.GET("/transactions/:id/attachments", attachmentsHandlerWrapper(api))
+	apiRoutes.POST("/transactions/:id/attachments", uploadAttachmentHandlerWrapper(api))
+	apiRoutes.DELETE("/attachments/:id", deregisterAttachmentHandlerWrapper(api))
+	apiRoutes.GET("/transactions/:id/receipt", receiptHandlerWrapper(api))
+	apiRoutes.PUT("/transactions/:id/receipt", putReceiptHandlerWrapper(api))
+	apiRoutes.DELETE("/transactions/:id/receipt", deleteReceiptHandlerWrapper(api))
+	apiRoutes.GET("/pots", potsHandlerWrapper(api))
+	apiRoutes.PUT("/pots/:id/deposit", potDepositHandlerWrapper(api))
+	apiRoutes.PUT("/pots/:id/withdraw", potWithdrawHandlerWrapper(api))
+	apiRoutes.POST("/feed", feedHandlerWrapper(notifier))
+
+	return router, refresher
 }
 
 func pingHandler(c *gin.Context) {
@@ -64,37 +156,84 @@ func pingHandler(c *gin.Context) {
 	})
 }
 
-func authHandlerWrapper(clientID string) func(c *gin.Context) {
-	state = getRandomString()
+// healthHandlerWrapper reports whether we can reach Monzo. It answers 503
+// while the breaker is open so that load balancers can tell.
+func healthHandlerWrapper(breaker *monzo.Breaker) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		status := breaker.Status()
+		if status.State == monzo.BreakerOpen {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "degraded",
+				"monzo":  status,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+			"monzo":  status,
+		})
+	}
+}
 
+func authHandlerWrapper(cfg Config, states *stateManager) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		link := url.URL{
-			Scheme:   "https",
-			Host:     "auth.getmondo.co.uk",
-			RawQuery: "client_id=" + clientID + "&redirect_uri=https://" + c.Request.Host + "/auth/callback&response_type=code&state=" + state,
+		link, err := url.Parse(cfg.AuthURL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		state, challenge := states.Issue(getSession(c).ID)
+		query := url.Values{}
+		query.Set("client_id", cfg.ClientID)
+		query.Set("redirect_uri", redirectURI(c, cfg))
+		query.Set("response_type", "code")
+		query.Set("state", state)
+		if challenge != "" {
+			query.Set("code_challenge", challenge)
+			query.Set("code_challenge_method", "S256")
 		}
+		link.RawQuery = query.Encode()
 		c.Redirect(http.StatusTemporaryRedirect, link.String())
 	}
 }
 
-func setAuthCallbackEndpointWrapper(clientID, clientSecret string) func(c *gin.Context) {
+func setAuthCallbackEndpointWrapper(cfg Config, api *monzo.Client, tokens *tokenManager, sessions *sessionManager, states *stateManager, approvals *approvalTracker, onApproved func(c *gin.Context, token AuthResponse)) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		client := &http.Client{}
+		ctx := c.Request.Context()
+		sess := getSession(c)
+
+		var authResponse AuthResponse
 		var err error
 
+		authResponse, err = loadUserToken(tokens, sess)
+		if err != nil && err != ErrTokenNotFound {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
 		if authResponse.AuthExpiryTimestamp <= time.Now().Unix() {
 			fmt.Printf("Token expired\n")
 
 			if authResponse.RefreshToken != "" {
 				fmt.Printf("Refreshing token\n")
-				formData := map[string]string{
-					"grant_type":    "refresh_token",
-					"client_id":     clientID,
-					"client_secret": clientSecret,
-					"refresh_token": authResponse.RefreshToken,
+				authResponse, err = tokens.Refresh(authResponse)
+
+				// The refresh token is dead, so forget it and have the user log in again
+				if monzo.IsInvalidGrant(err) {
+					fmt.Printf("Refresh token rejected, restarting login: %s\n", err)
+					approvals.Forget(sess.UserID)
+					err = tokens.Delete(sess.UserID)
+					if err != nil {
+						c.JSON(http.StatusInternalServerError, err.Error())
+						return
+					}
+
+					sess.UserID = ""
+					sessions.save(c, sess)
+					c.Redirect(http.StatusTemporaryRedirect, "/auth")
+					return
 				}
-
-				err = getAuthenticationToken(client, formData)
 			} else {
 				err = c.Request.ParseForm()
 				if err != nil {
@@ -106,7 +245,8 @@ func setAuthCallbackEndpointWrapper(clientID, clientSecret string) func(c *gin.C
 
 				authorizationCode := c.Request.Form.Get("code")
 				monzoState := c.Request.Form.Get("state")
-				if monzoState != state {
+				codeVerifier, ok := states.Consume(monzoState, sess.ID)
+				if !ok {
 					c.JSON(http.StatusNotFound, gin.H{
 						"Error": "The state does not match, what are you trying to do",
 					})
@@ -115,13 +255,20 @@ func setAuthCallbackEndpointWrapper(clientID, clientSecret string) func(c *gin.C
 
 				formData := map[string]string{
 					"grant_type":    "authorization_code",
-					"client_id":     clientID,
-					"client_secret": clientSecret,
-					"redirect_uri":  "https://" + c.Request.Host + "/auth/callback",
+					"client_id":     cfg.ClientID,
+					"client_secret": cfg.ClientSecret,
+					"redirect_uri":  redirectURI(c, cfg),
 					"code":          authorizationCode,
 				}
+				if codeVerifier != "" {
+					formData["code_verifier"] = codeVerifier
+				}
 
-				err = getAuthenticationToken(client, formData)
+				authResponse, err = getAuthenticationToken(ctx, api, tokens, formData)
+				if err == nil {
+					// Every new login has to be approved in the Monzo app again
+					approvals.Set(authResponse.UserID, approvalPending)
+				}
 			}
 
 			if err != nil {
@@ -129,80 +276,121 @@ func setAuthCallbackEndpointWrapper(clientID, clientSecret string) func(c *gin.C
 				return
 			}
 
-			authResponse.AuthExpiryTimestamp = time.Now().Unix() + int64(authResponse.ExpiresIn)
+			sessions.login(c, sess, authResponse.UserID)
 		}
 
-		req, err := http.NewRequest("GET", "https://api.monzo.com/ping/whoami", nil)
+		whoami, err := api.WithTokens(monzo.StaticToken(authResponse.AccessToken)).WhoAmI(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, err.Error())
+			c.JSON(http.StatusBadRequest, err.Error())
 			return
 		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authResponse.AccessToken))
-		req.Header.Set("Content-Type", "application/json")
 
-		resp1, err := client.Do(req)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, err.Error())
+		if !whoami.Authenticated {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"Error": "Monzo did not accept the token, log in again",
+			})
 			return
 		}
 
-		respBody, err := ioutil.ReadAll(resp1.Body)
+		// The token is either brand new or has just been checked for expiry
+		approval, err := approvals.Current(authResponse.UserID, monzo.StaticToken(authResponse.AccessToken))
 		if err != nil {
-			c.JSON(http.StatusBadRequest, err.Error())
+			c.JSON(http.StatusBadGateway, err.Error())
 			return
 		}
 
-		var bearerResponse BearerResponse
-		err = json.Unmarshal(respBody, &bearerResponse)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, err.Error())
-			return
+		if approval == approvalApproved {
+			onApproved(c, authResponse)
 		}
 
-		c.JSON(resp1.StatusCode, gin.H{
-			"message": "authentication successful",
+		message := "authentication successful"
+		if approval == approvalPending {
+			message = "authentication successful, now approve access in the Monzo app"
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":    message,
+			"approval":   approval,
+			"status_url": "/auth/status",
 		})
 	}
 }
 
-func getEnv(v string) string {
-	env := os.Getenv(v)
-	if env == "" {
-		panic(fmt.Sprintf("No %s defined in the env", v))
+// redirectURI is where Monzo sends users back to after they log in.
+func redirectURI(c *gin.Context, cfg Config) string {
+	return publicBaseURL(c, cfg) + "/auth/callback"
+}
+
+// publicBaseURL is the scheme and host users reach us on. Without a
+// configured PublicURL it is worked out from the request, assuming https
+// unless a trusted proxy says otherwise.
+func publicBaseURL(c *gin.Context, cfg Config) string {
+	if cfg.PublicURL != "" {
+		return strings.TrimRight(cfg.PublicURL, "/")
+	}
+
+	scheme := "https"
+	host := c.Request.Host
+	if cfg.TrustProxyHeaders {
+		if proto := firstHeaderValue(c, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := firstHeaderValue(c, "X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
 	}
+	return scheme + "://" + host
+}
 
-	return env
+// firstHeaderValue returns the first entry of a comma separated header, which
+// is the one the outermost proxy set.
+func firstHeaderValue(c *gin.Context, name string) string {
+	value := c.Request.Header.Get(name)
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
 }
 
-func getRandomString() string {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	number := r.Int63()
-	return strconv.FormatInt(number, 10)
+// refreshAuthenticationToken swaps refreshToken for a new token and saves it.
+func refreshAuthenticationToken(ctx context.Context, api *monzo.Client, cfg Config, store TokenStore, refreshToken string) (AuthResponse, error) {
+	formData := map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     cfg.ClientID,
+		"client_secret": cfg.ClientSecret,
+		"refresh_token": refreshToken,
+	}
+
+	return getAuthenticationToken(ctx, api, store, formData)
 }
 
-func getAuthenticationToken(client *http.Client, formData map[string]string) error {
+// getAuthenticationToken exchanges formData for a token at Monzo and saves the
+// result in store under the token's user ID. A refusal from Monzo comes back
+// as a *monzo.OAuthError.
+func getAuthenticationToken(ctx context.Context, api *monzo.Client, store TokenStore, formData map[string]string) (AuthResponse, error) {
 	form := url.Values{}
 	for k, v := range formData {
 		form.Add(k, v)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.monzo.com/oauth2/token", strings.NewReader(form.Encode()))
+	token, err := api.ExchangeToken(ctx, form)
 	if err != nil {
-		return err
+		return AuthResponse{}, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	authResponse := AuthResponse{
+		AccessToken:         token.AccessToken,
+		ClientID:            token.ClientID,
+		ExpiresIn:           token.ExpiresIn,
+		RefreshToken:        token.RefreshToken,
+		TokenType:           token.TokenType,
+		UserID:              token.UserID,
+		AuthExpiryTimestamp: time.Now().Unix() + int64(token.ExpiresIn),
+		SessionsSince:       time.Now(),
 	}
-
-	defer resp.Body.Close()
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	// Refreshes and logins from another browser keep the user's sessions
+	current, err := store.Load(authResponse.UserID)
+	if err == nil {
+		authResponse.SessionsSince = current.SessionsSince
 	}
-
-	err = json.Unmarshal(respBody, &authResponse)
-	return err
+	return authResponse, store.Save(authResponse.UserID, authResponse)
 }
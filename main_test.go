@@ -1,16 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestPing(t *testing.T) {
-	server := newServer()
+	server := testServer(testConfig())
 
 	req, err := http.NewRequest("GET", "/ping", nil)
 	assert.NoError(t, err)
@@ -25,8 +37,16 @@ func TestPing(t *testing.T) {
 	assert.True(t, strings.Contains(w.Body.String(), "pong"), "Ping endpoint returned the wrong result")
 }
 
+func TestHealth(t *testing.T) {
+	browser := &fakeBrowser{server: testServer(testConfig())}
+
+	w := browser.get(t, "/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok","monzo":{"state":"closed","consecutive_failures":0,"cached_responses":0}}`, w.Body.String())
+}
+
 func TestAuth(t *testing.T) {
-	server := newServer()
+	server := testServer(testConfig())
 
 	req, err := http.NewRequest("GET", "/auth", nil)
 	assert.NoError(t, err)
@@ -40,3 +60,727 @@ func TestAuth(t *testing.T) {
 
 	assert.True(t, strings.Contains(w.Result().Header.Get("Location"), "https://auth.getmondo.co.uk"), "Ping endpoint returned the wrong result")
 }
+
+func TestLoginFlow(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	server := testServer(monzo.config())
+	browser := &fakeBrowser{server: server}
+
+	w := browser.login(t, "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to log in, returned %d: %s", w.Code, w.Body.String())
+	}
+	assert.True(t, strings.Contains(w.Body.String(), "authentication successful"))
+	assert.Equal(t, 1, monzo.tokenRequests)
+
+	// The token is still valid, so coming back doesn't need a new one.
+	w = browser.get(t, "/auth/callback")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, monzo.tokenRequests)
+}
+
+func TestLoginFlowWithPKCE(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	cfg := monzo.config()
+	cfg.UsePKCE = true
+	server := testServer(cfg)
+
+	browser := &fakeBrowser{server: server, monzo: monzo}
+	w := browser.login(t, "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to log in, returned %d: %s", w.Code, w.Body.String())
+	}
+	assert.Equal(t, 1, monzo.tokenRequests)
+
+	// The verifier has to be the one for this login's challenge
+	other := &fakeBrowser{server: server}
+	query := other.startLogin(t)
+	monzo.authorize("bob", browser.startLogin(t).Get("code_challenge"))
+	w = other.get(t, "/auth/callback?code=bob&state="+url.QueryEscape(query.Get("state")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// And one has to be sent for a code issued with a challenge
+	server = testServer(monzo.config())
+	other = &fakeBrowser{server: server}
+	query = other.startLogin(t)
+	assert.Empty(t, query.Get("code_challenge"))
+	w = other.get(t, "/auth/callback?code=bob&state="+url.QueryEscape(query.Get("state")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCallbackRejectsAnotherBrowsersState(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	server := testServer(monzo.config())
+	victim := &fakeBrowser{server: server}
+	attacker := &fakeBrowser{server: server}
+
+	state := victim.startLogin(t).Get("state")
+	w := attacker.get(t, "/auth/callback?code=alice&state="+url.QueryEscape(state))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, monzo.tokenRequests)
+}
+
+func TestLoginFlowKeepsUsersApart(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	server := testServer(monzo.config())
+	alice := &fakeBrowser{server: server}
+	bob := &fakeBrowser{server: server}
+
+	assert.Equal(t, http.StatusOK, alice.login(t, "alice").Code)
+	assert.Equal(t, http.StatusOK, bob.login(t, "bob").Code)
+
+	alice.get(t, "/auth/callback")
+	bob.get(t, "/auth/callback")
+	assert.Equal(t, []string{"Bearer access-alice", "Bearer access-bob", "Bearer access-alice", "Bearer access-bob"}, monzo.whoamiTokens)
+}
+
+func TestCallbackRefreshesExpiredToken(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.expiresIn = 0
+
+	server := testServer(monzo.config())
+	browser := &fakeBrowser{server: server}
+	assert.Equal(t, http.StatusOK, browser.login(t, "alice").Code)
+
+	w := browser.get(t, "/auth/callback")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, monzo.tokenRequests)
+	assert.Equal(t, "Bearer access-alice", monzo.whoamiTokens[len(monzo.whoamiTokens)-1])
+}
+
+func TestCallbackRestartsLoginWhenRefreshIsRejected(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.expiresIn = 0
+	monzo.rejectRefresh = true
+
+	server := testServer(monzo.config())
+	browser := &fakeBrowser{server: server}
+	assert.Equal(t, http.StatusOK, browser.login(t, "alice").Code)
+
+	w := browser.get(t, "/auth/callback")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+
+	// Logging in again goes through the authorization code, not the dead refresh token.
+	monzo.expiresIn = 21600
+	assert.Equal(t, http.StatusOK, browser.login(t, "alice").Code)
+}
+
+func TestConcurrentCallbacksShareOneRefresh(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.expiresIn = 0
+
+	server := testServer(monzo.config())
+	browser := &fakeBrowser{server: server}
+	assert.Equal(t, http.StatusOK, browser.login(t, "alice").Code)
+
+	monzo.mu.Lock()
+	monzo.expiresIn = 21600
+	monzo.mu.Unlock()
+
+	// Every request carries the same cookies, as if one user had many tabs open.
+	var cookies []*http.Cookie
+	for _, cookie := range browser.cookies {
+		cookies = append(cookies, cookie)
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("GET", "/auth/callback", nil)
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+
+	monzo.mu.Lock()
+	defer monzo.mu.Unlock()
+	assert.Equal(t, 2, monzo.tokenRequests, "Expected one login and one shared refresh")
+}
+
+func TestLoginWaitsForApproval(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	monzo.unapproved = true
+
+	server := testServer(monzo.config())
+	browser := &fakeBrowser{server: server}
+
+	w := browser.login(t, "alice")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"approval":"pending"`))
+
+	w = browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"approval":"pending"`))
+	assert.NotEmpty(t, w.Header().Get("Refresh"), "The status page should keep polling while approval is pending")
+
+	monzo.unapproved = false
+
+	w = browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"approval":"approved"`))
+	assert.Empty(t, w.Header().Get("Refresh"))
+}
+
+func TestAuthStatusNeedsLogin(t *testing.T) {
+	browser := &fakeBrowser{server: testServer(testConfig())}
+
+	w := browser.get(t, "/auth/status")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func testConfig() Config {
+	cfg := defaultConfig()
+	cfg.ClientID = "client"
+	cfg.ClientSecret = "secret"
+	// Tests make requests far faster than Monzo would allow
+	cfg.RateLimit = 1000
+	return cfg
+}
+
+// testServer is newServer without its token refresher running, so that tests
+// don't leave it ticking away behind them.
+func testServer(cfg Config) *gin.Engine {
+	server, _ := newServer(cfg)
+	return server
+}
+
+// fakeMonzo stands in for Monzo's auth and API servers. The authorization
+// code doubles as the user's name.
+type fakeMonzo struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	expiresIn     int
+	rejectRefresh bool
+	unapproved    bool
+	// revoked turns away every API call, as Monzo does once the user takes
+	// access away in the app.
+	revoked bool
+	// transactionPages counts requests for pages of transactions.
+	transactionPages int
+	potBalance       int64
+	dedupeIDs        map[string]bool
+	feedItems        []url.Values
+	webhooks         []gin.H
+	txMetadata       map[string]map[string]string
+	txAmounts        map[string]int64
+	receipts         map[string]gin.H
+	attachments      map[string][]gin.H
+	uploads          map[string][]byte
+	webhookCount     int
+	tokenRequests    int
+	whoamiTokens     []string
+	logoutTokens     []string
+	// refreshTokens maps each refresh token still usable to its user's
+	// name. Like Monzo, each one works once.
+	refreshTokens map[string]string
+	// challenges maps authorization codes to the PKCE code_challenge they
+	// were issued for, see authorize.
+	challenges map[string]string
+	// potFailures is how many more pot transfers to answer with a 500, after
+	// moving the money.
+	potFailures int
+	// jointAccount, if set, is the ID of a joint account every user shares.
+	jointAccount string
+}
+
+func newFakeMonzo() *fakeMonzo {
+	monzo := &fakeMonzo{
+		expiresIn:     21600,
+		refreshTokens: map[string]string{},
+		potBalance:    1000,
+		dedupeIDs:     map[string]bool{},
+		txMetadata:    map[string]map[string]string{},
+		txAmounts:     map[string]int64{},
+		receipts:      map[string]gin.H{},
+		attachments:   map[string][]gin.H{},
+		uploads:       map[string][]byte{},
+		challenges:    map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		monzo.tokenRequests++
+		r.ParseForm()
+
+		name := r.Form.Get("code")
+		if r.Form.Get("grant_type") == "authorization_code" {
+			// Like Monzo, a code issued with a challenge needs its verifier
+			// and a code issued without one takes no verifier
+			challenge, verifier := monzo.challenges[name], r.Form.Get("code_verifier")
+			sum := sha256.Sum256([]byte(verifier))
+			if challenge != "" && challenge != base64.RawURLEncoding.EncodeToString(sum[:]) || challenge == "" && verifier != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(gin.H{"error": "invalid_grant", "error_description": "code_verifier does not match"})
+				return
+			}
+		}
+		if r.Form.Get("grant_type") == "refresh_token" {
+			refreshToken := r.Form.Get("refresh_token")
+			name = monzo.refreshTokens[refreshToken]
+			delete(monzo.refreshTokens, refreshToken)
+
+			if monzo.rejectRefresh || name == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(gin.H{"error": "invalid_grant", "error_description": "refresh token revoked"})
+				return
+			}
+		}
+
+		refreshToken := fmt.Sprintf("refresh-%s-%d", name, monzo.tokenRequests)
+		monzo.refreshTokens[refreshToken] = name
+
+		json.NewEncoder(w).Encode(gin.H{
+			"access_token":  "access-" + name,
+			"client_id":     r.Form.Get("client_id"),
+			"expires_in":    monzo.expiresIn,
+			"refresh_token": refreshToken,
+			"token_type":    "Bearer",
+			"user_id":       "user_" + name,
+		})
+	})
+	mux.HandleFunc("/ping/whoami", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		monzo.whoamiTokens = append(monzo.whoamiTokens, r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(gin.H{"authenticated": true})
+	})
+	mux.HandleFunc("/oauth2/logout", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		monzo.logoutTokens = append(monzo.logoutTokens, r.Header.Get("Authorization"))
+	})
+	mux.HandleFunc("/accounts", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		if monzo.revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(gin.H{"code": "unauthorized.bad_access_token"})
+			return
+		}
+		if monzo.unapproved {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(gin.H{"code": "forbidden.insufficient_permissions"})
+			return
+		}
+		name := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer access-")
+		accounts := []gin.H{}
+		accountType := r.URL.Query().Get("account_type")
+		if accountType == "" || accountType == "uk_retail" {
+			accounts = append(accounts, gin.H{"id": "acc_" + name, "type": "uk_retail", "currency": "GBP"})
+		}
+		if monzo.jointAccount != "" && (accountType == "" || accountType == "uk_retail_joint") {
+			accounts = append(accounts, gin.H{"id": monzo.jointAccount, "type": "uk_retail_joint", "currency": "GBP"})
+		}
+		json.NewEncoder(w).Encode(gin.H{"accounts": accounts})
+	})
+	mux.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		monzo.transactionPages++
+		query := r.URL.Query()
+		since, sinceErr := time.Parse(time.RFC3339, query.Get("since"))
+		before, beforeErr := time.Parse(time.RFC3339, query.Get("before"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		// One transaction an hour through the first ten days of 2018
+		transactions := []gin.H{}
+		for i := 0; i < 240 && len(transactions) < limit; i++ {
+			id := fmt.Sprintf("tx_%03d", i)
+			created := time.Date(2018, 1, 1, i, 0, 0, 0, time.UTC)
+			if sinceErr == nil && created.Before(since) || sinceErr != nil && id <= query.Get("since") {
+				continue
+			}
+			if beforeErr == nil && !created.Before(before) {
+				continue
+			}
+			transactions = append(transactions, gin.H{"id": id, "account_id": query.Get("account_id"), "amount": -100, "created": created})
+		}
+		json.NewEncoder(w).Encode(gin.H{"transactions": transactions})
+	})
+	mux.HandleFunc("/transactions/", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		id := strings.TrimPrefix(r.URL.Path, "/transactions/")
+		metadata, ok := monzo.txMetadata[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(gin.H{"code": "not_found.transaction"})
+			return
+		}
+
+		if r.Method == "PATCH" {
+			r.ParseForm()
+			for key := range r.PostForm {
+				name := strings.TrimSuffix(strings.TrimPrefix(key, "metadata["), "]")
+				if value := r.PostForm.Get(key); value != "" {
+					metadata[name] = value
+				} else {
+					delete(metadata, name)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(gin.H{"transaction": gin.H{"id": id, "amount": monzo.txAmounts[id], "currency": "GBP", "notes": metadata["notes"], "metadata": metadata, "attachments": monzo.attachments[id]}})
+	})
+	mux.HandleFunc("/transaction-receipts", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		switch r.Method {
+		case "PUT":
+			var receipt gin.H
+			json.NewDecoder(r.Body).Decode(&receipt)
+			monzo.receipts[receipt["external_id"].(string)] = receipt
+			w.Write([]byte("{}"))
+		case "DELETE":
+			delete(monzo.receipts, r.URL.Query().Get("external_id"))
+			w.Write([]byte("{}"))
+		default:
+			receipt, ok := monzo.receipts[r.URL.Query().Get("external_id")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(gin.H{"code": "not_found.receipt"})
+				return
+			}
+			json.NewEncoder(w).Encode(gin.H{"receipt": receipt})
+		}
+	})
+	mux.HandleFunc("/attachment/upload", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		json.NewEncoder(w).Encode(gin.H{"file_url": "https://files.example.com/" + r.PostForm.Get("file_name"), "upload_url": monzo.URL + "/upload/" + r.PostForm.Get("file_name")})
+	})
+	mux.HandleFunc("/upload/", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		monzo.uploads[strings.TrimPrefix(r.URL.Path, "/upload/")] = body
+	})
+	mux.HandleFunc("/attachment/register", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		r.ParseForm()
+		id := r.PostForm.Get("external_id")
+		attachment := gin.H{"id": fmt.Sprintf("attach_%d", len(monzo.attachments[id])+1), "external_id": id, "file_url": r.PostForm.Get("file_url"), "file_type": r.PostForm.Get("file_type")}
+		monzo.attachments[id] = append(monzo.attachments[id], attachment)
+		json.NewEncoder(w).Encode(gin.H{"attachment": attachment})
+	})
+	mux.HandleFunc("/attachment/deregister", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		r.ParseForm()
+		for id, attachments := range monzo.attachments {
+			for i, attachment := range attachments {
+				if attachment["id"] == r.PostForm.Get("id") {
+					monzo.attachments[id] = append(attachments[:i], attachments[i+1:]...)
+					w.Write([]byte("{}"))
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(gin.H{"code": "not_found.attachment"})
+	})
+	mux.HandleFunc("/pots", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		json.NewEncoder(w).Encode(gin.H{"pots": []gin.H{{"id": "pot_1", "name": "Savings", "balance": monzo.potBalance, "currency": "GBP"}}})
+	})
+	mux.HandleFunc("/pots/pot_1/", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		r.ParseForm()
+		amount, _ := strconv.ParseInt(r.Form.Get("amount"), 10, 64)
+		if r.Method != "PUT" || amount <= 0 || r.Form.Get("dedupe_id") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(gin.H{"code": "bad_request"})
+			return
+		}
+
+		// Like Monzo, only act on each dedupe ID once
+		if !monzo.dedupeIDs[r.Form.Get("dedupe_id")] {
+			monzo.dedupeIDs[r.Form.Get("dedupe_id")] = true
+			if strings.HasSuffix(r.URL.Path, "/withdraw") {
+				amount = -amount
+			}
+			monzo.potBalance += amount
+		}
+		if monzo.potFailures > 0 {
+			monzo.potFailures--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(gin.H{"code": "internal_error"})
+			return
+		}
+		json.NewEncoder(w).Encode(gin.H{"id": "pot_1", "name": "Savings", "balance": monzo.potBalance, "currency": "GBP"})
+	})
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		r.ParseForm()
+		monzo.feedItems = append(monzo.feedItems, r.PostForm)
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		r.ParseForm()
+		accountID := r.Form.Get("account_id")
+		if r.Method == "POST" {
+			monzo.webhookCount++
+			webhook := gin.H{"id": fmt.Sprintf("webhook_%d", monzo.webhookCount), "account_id": accountID, "url": r.Form.Get("url")}
+			monzo.webhooks = append(monzo.webhooks, webhook)
+			json.NewEncoder(w).Encode(gin.H{"webhook": webhook})
+			return
+		}
+
+		webhooks := []gin.H{}
+		for _, webhook := range monzo.webhooks {
+			if webhook["account_id"] == accountID {
+				webhooks = append(webhooks, webhook)
+			}
+		}
+		json.NewEncoder(w).Encode(gin.H{"webhooks": webhooks})
+	})
+	mux.HandleFunc("/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		defer monzo.mu.Unlock()
+
+		id := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+		for i, webhook := range monzo.webhooks {
+			if webhook["id"] == id && r.Method == "DELETE" {
+				monzo.webhooks = append(monzo.webhooks[:i], monzo.webhooks[i+1:]...)
+				w.Write([]byte("{}"))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/balance", func(w http.ResponseWriter, r *http.Request) {
+		monzo.mu.Lock()
+		revoked := monzo.revoked
+		monzo.mu.Unlock()
+		if revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(gin.H{"code": "unauthorized.bad_access_token"})
+			return
+		}
+
+		name := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer access-")
+		if r.URL.Query().Get("account_id") != "acc_"+name {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(gin.H{"code": "forbidden.insufficient_permissions", "message": "Access forbidden due to insufficient permissions"})
+			return
+		}
+		json.NewEncoder(w).Encode(gin.H{"balance": 5000, "total_balance": 6000, "currency": "GBP", "spend_today": -250})
+	})
+
+	monzo.Server = httptest.NewServer(mux)
+	return monzo
+}
+
+// authorize hands out code for a login that sent challenge, as Monzo's login
+// page does.
+func (m *fakeMonzo) authorize(code, challenge string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.challenges[code] = challenge
+}
+
+func (m *fakeMonzo) config() Config {
+	cfg := testConfig()
+	cfg.AuthURL = m.URL
+	cfg.APIURL = m.URL
+	return cfg
+}
+
+// fakeBrowser sends requests to server, keeping hold of the cookies it is given.
+type fakeBrowser struct {
+	server  http.Handler
+	cookies map[string]*http.Cookie
+	// monzo, if set, is told about each login's PKCE challenge, as Monzo's
+	// login page would be.
+	monzo *fakeMonzo
+}
+
+func (b *fakeBrowser) do(t *testing.T, req *http.Request) *httptest.ResponseRecorder {
+	for _, cookie := range b.cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	b.server.ServeHTTP(w, req)
+
+	if b.cookies == nil {
+		b.cookies = map[string]*http.Cookie{}
+	}
+	for _, cookie := range w.Result().Cookies() {
+		b.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+func (b *fakeBrowser) get(t *testing.T, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	assert.NoError(t, err)
+	return b.do(t, req)
+}
+
+// post sends body as JSON.
+func (b *fakeBrowser) post(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	return b.sendJSON(t, "POST", path, body)
+}
+
+// put sends body as JSON.
+func (b *fakeBrowser) put(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	return b.sendJSON(t, "PUT", path, body)
+}
+
+// patch sends body as JSON.
+func (b *fakeBrowser) patch(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	return b.sendJSON(t, "PATCH", path, body)
+}
+
+// upload sends data as the file field of a multipart form.
+func (b *fakeBrowser) upload(t *testing.T, path, fileName string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	assert.NoError(t, err)
+	part.Write(data)
+	form.Close()
+
+	req, err := http.NewRequest("POST", path, &body)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return b.do(t, req)
+}
+
+// send sends body with the given content type.
+func (b *fakeBrowser) send(t *testing.T, method, path, contentType, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	return b.do(t, req)
+}
+
+func (b *fakeBrowser) sendJSON(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	return b.send(t, method, path, "application/json", body)
+}
+
+// startLogin hits /auth and returns the query Monzo's login page would be sent.
+func (b *fakeBrowser) startLogin(t *testing.T) url.Values {
+	w := b.get(t, "/auth")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	return location.Query()
+}
+
+// login runs the whole authorization-code flow as the named user.
+func (b *fakeBrowser) login(t *testing.T, name string) *httptest.ResponseRecorder {
+	query := b.startLogin(t)
+	if b.monzo != nil {
+		b.monzo.authorize(name, query.Get("code_challenge"))
+	}
+	return b.get(t, "/auth/callback?code="+name+"&state="+url.QueryEscape(query.Get("state")))
+}
+
+func TestAuthEncodesRedirectURI(t *testing.T) {
+	cfg := testConfig()
+	cfg.PublicURL = "http://localhost:8080/"
+	cfg.UsePKCE = true
+	server := testServer(cfg)
+
+	req, err := http.NewRequest("GET", "/auth", nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+
+	query := location.Query()
+	assert.Equal(t, "http://localhost:8080/auth/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "client", query.Get("client_id"))
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.NotEmpty(t, query.Get("state"))
+	assert.NotEmpty(t, query.Get("code_challenge"))
+}
+
+func TestPublicBaseURL(t *testing.T) {
+	trusting := testConfig()
+	trusting.TrustProxyHeaders = true
+
+	configured := testConfig()
+	configured.PublicURL = "https://askmonzo.example.com"
+
+	for _, test := range []struct {
+		cfg      Config
+		headers  map[string]string
+		expected string
+	}{
+		{testConfig(), nil, "https://askmonzo.herokuapp.com"},
+		{testConfig(), map[string]string{"X-Forwarded-Host": "evil.example.com"}, "https://askmonzo.herokuapp.com"},
+		{trusting, map[string]string{"X-Forwarded-Host": "askmonzo.example.com, internal", "X-Forwarded-Proto": "https"}, "https://askmonzo.example.com"},
+		{trusting, map[string]string{"X-Forwarded-Proto": "http"}, "http://askmonzo.herokuapp.com"},
+		{trusting, map[string]string{"X-Forwarded-Proto": "gopher"}, "https://askmonzo.herokuapp.com"},
+		{configured, map[string]string{"X-Forwarded-Host": "evil.example.com"}, "https://askmonzo.example.com"},
+	} {
+		req, err := http.NewRequest("GET", "http://askmonzo.herokuapp.com/auth", nil)
+		assert.NoError(t, err)
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+
+		c := &gin.Context{Request: req}
+		assert.Equal(t, test.expected, publicBaseURL(c, test.cfg), "Headers %v", test.headers)
+	}
+}
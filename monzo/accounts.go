@@ -0,0 +1,60 @@
+package monzo
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Account types Monzo lets us filter /accounts by.
+const (
+	AccountTypeRetail      = "uk_retail"
+	AccountTypeRetailJoint = "uk_retail_joint"
+)
+
+// Account is one of the user's current accounts.
+type Account struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description"`
+	Type        string    `json:"type"`
+	Currency    string    `json:"currency"`
+	Closed      bool      `json:"closed"`
+	Created     time.Time `json:"created"`
+}
+
+// Balance is an account's balance in minor units, pence for GBP.
+type Balance struct {
+	Balance      int64  `json:"balance"`
+	TotalBalance int64  `json:"total_balance"`
+	Currency     string `json:"currency"`
+	SpendToday   int64  `json:"spend_today"`
+}
+
+// Accounts lists the user's accounts. An empty accountType lists all of them,
+// otherwise only accounts of that type, such as AccountTypeRetail.
+func (c *Client) Accounts(ctx context.Context, accountType string) ([]Account, error) {
+	path := "/accounts"
+	if accountType != "" {
+		path += "?" + url.Values{"account_type": {accountType}}.Encode()
+	}
+
+	var resp struct {
+		Accounts []Account `json:"accounts"`
+	}
+	err := c.call(ctx, "GET", path, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Accounts, nil
+}
+
+// Balance returns the balance of the account with the given ID.
+func (c *Client) Balance(ctx context.Context, accountID string) (*Balance, error) {
+	var balance Balance
+	path := "/balance?" + url.Values{"account_id": {accountID}}.Encode()
+	err := c.call(ctx, "GET", path, nil, &balance)
+	if err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
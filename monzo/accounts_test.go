@@ -0,0 +1,45 @@
+package monzo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccounts(t *testing.T) {
+	var accountTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accountTypes = append(accountTypes, r.URL.Query().Get("account_type"))
+		w.Write([]byte(`{"accounts":[{"id":"acc_1","description":"Alice","type":"uk_retail","currency":"GBP","created":"2018-01-02T03:04:05Z"}]}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("access"))
+
+	accounts, err := client.Accounts(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 1)
+	assert.Equal(t, "acc_1", accounts[0].ID)
+	assert.Equal(t, AccountTypeRetail, accounts[0].Type)
+	assert.Equal(t, 2018, accounts[0].Created.Year())
+
+	_, err = client.Accounts(context.Background(), AccountTypeRetailJoint)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "uk_retail_joint"}, accountTypes)
+}
+
+func TestBalance(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/balance", r.URL.Path)
+		assert.Equal(t, "acc_1", r.URL.Query().Get("account_id"))
+		w.Write([]byte(`{"balance":5000,"total_balance":6000,"currency":"GBP","spend_today":-250}`))
+	}))
+	defer server.Close()
+
+	balance, err := newTestClient(server.URL, StaticToken("access")).Balance(context.Background(), "acc_1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Balance{Balance: 5000, TotalBalance: 6000, Currency: "GBP", SpendToday: -250}, balance)
+}
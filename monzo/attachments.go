@@ -0,0 +1,124 @@
+package monzo
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Attachment is a file, such as a photo of a receipt, attached to a
+// transaction.
+type Attachment struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	ExternalID string    `json:"external_id"`
+	FileURL    string    `json:"file_url"`
+	FileType   string    `json:"file_type"`
+	Created    time.Time `json:"created"`
+}
+
+// Upload is where to PUT a file before registering it as an attachment.
+type Upload struct {
+	FileURL   string `json:"file_url"`
+	UploadURL string `json:"upload_url"`
+}
+
+// RequestUpload asks Monzo where to upload a file of the given type and
+// length in bytes.
+func (c *Client) RequestUpload(ctx context.Context, fileName, fileType string, contentLength int64) (*Upload, error) {
+	form := url.Values{}
+	form.Set("file_name", fileName)
+	form.Set("file_type", fileType)
+	form.Set("content_length", strconv.FormatInt(contentLength, 10))
+
+	var upload Upload
+	err := c.call(ctx, "POST", "/attachment/upload", form, &upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// uploadHTTPClient does the PUTs to upload URLs. They aren't Monzo's API, so
+// they stay clear of its rate limits, retries and breaker, and a body that
+// can't be read twice is never sent twice.
+var uploadHTTPClient = &http.Client{Timeout: defaultClientTimeout}
+
+// UploadFile PUTs contentLength bytes of body to the upload's URL. The URL is
+// already signed, so no access token is sent with it.
+func (c *Client) UploadFile(ctx context.Context, upload *Upload, fileType string, body io.Reader, contentLength int64) error {
+	req, err := http.NewRequest("PUT", upload.UploadURL, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = contentLength
+	req.Header.Set("Content-Type", fileType)
+
+	resp, err := uploadHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return newAPIError(resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+// RegisterAttachment attaches an uploaded file to the transaction.
+func (c *Client) RegisterAttachment(ctx context.Context, transactionID, fileURL, fileType string) (*Attachment, error) {
+	form := url.Values{}
+	form.Set("external_id", transactionID)
+	form.Set("file_url", fileURL)
+	form.Set("file_type", fileType)
+
+	var resp struct {
+		Attachment Attachment `json:"attachment"`
+	}
+	err := c.call(ctx, "POST", "/attachment/register", form, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Attachment, nil
+}
+
+// DeregisterAttachment removes the attachment with the given ID from its
+// transaction.
+func (c *Client) DeregisterAttachment(ctx context.Context, attachmentID string) error {
+	return c.call(ctx, "POST", "/attachment/deregister", url.Values{"id": {attachmentID}}, nil)
+}
+
+// Attachments lists the transaction's attachments.
+func (c *Client) Attachments(ctx context.Context, transactionID string) ([]Attachment, error) {
+	tx, err := c.Transaction(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Attachments, nil
+}
+
+// AttachFile runs the whole attachment flow: it uploads contentLength bytes
+// of body and attaches the file to the transaction.
+func (c *Client) AttachFile(ctx context.Context, transactionID, fileName, fileType string, body io.Reader, contentLength int64) (*Attachment, error) {
+	upload, err := c.RequestUpload(ctx, fileName, fileType, contentLength)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.UploadFile(ctx, upload, fileType, body, contentLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.RegisterAttachment(ctx, transactionID, upload.FileURL, fileType)
+}
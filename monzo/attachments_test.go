@@ -0,0 +1,93 @@
+package monzo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachFile(t *testing.T) {
+	var uploaded string
+	var attachments []Attachment
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/attachment/upload", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "receipt.png", r.PostForm.Get("file_name"))
+		assert.Equal(t, "image/png", r.PostForm.Get("file_type"))
+		assert.Equal(t, "7", r.PostForm.Get("content_length"))
+		json.NewEncoder(w).Encode(Upload{FileURL: "https://files.example.com/file_1", UploadURL: server.URL + "/s3/file_1"})
+	})
+	mux.HandleFunc("/s3/file_1", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		assert.Empty(t, r.Header.Get("Authorization"), "The upload URL is signed already")
+		assert.Equal(t, "image/png", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		uploaded = string(body)
+	})
+	mux.HandleFunc("/attachment/register", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		attachment := Attachment{ID: "attach_1", ExternalID: r.PostForm.Get("external_id"), FileURL: r.PostForm.Get("file_url"), FileType: r.PostForm.Get("file_type")}
+		attachments = append(attachments, attachment)
+		json.NewEncoder(w).Encode(map[string]interface{}{"attachment": attachment})
+	})
+	mux.HandleFunc("/attachment/deregister", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "attach_1", r.PostForm.Get("id"))
+		attachments = nil
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/transactions/tx_1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"transaction": Transaction{ID: "tx_1", Attachments: attachments}})
+	})
+
+	client := newTestClient(server.URL, StaticToken("access"))
+	ctx := context.Background()
+
+	attachment, err := client.AttachFile(ctx, "tx_1", "receipt.png", "image/png", strings.NewReader("receipt"), 7)
+	assert.NoError(t, err)
+	assert.Equal(t, &Attachment{ID: "attach_1", ExternalID: "tx_1", FileURL: "https://files.example.com/file_1", FileType: "image/png"}, attachment)
+	assert.Equal(t, "receipt", uploaded)
+
+	listed, err := client.Attachments(ctx, "tx_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Attachment{*attachment}, listed)
+
+	assert.NoError(t, client.DeregisterAttachment(ctx, "attach_1"))
+	listed, err = client.Attachments(ctx, "tx_1")
+	assert.NoError(t, err)
+	assert.Empty(t, listed)
+}
+
+func TestUploadFileReportsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code></Error>"))
+	}))
+	defer server.Close()
+
+	err := newTestClient(server.URL, nil).UploadFile(context.Background(), &Upload{UploadURL: server.URL}, "image/png", strings.NewReader("x"), 1)
+	assert.Equal(t, &APIError{StatusCode: 403, Code: "Forbidden"}, err)
+}
+
+func TestUploadFileBypassesTheAPIClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	api := &flakyTransport{down: true}
+	client := newTestClient(server.URL, nil)
+	client.HTTPClient = &http.Client{Transport: api}
+
+	err := client.UploadFile(context.Background(), &Upload{UploadURL: server.URL}, "image/png", strings.NewReader("x"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, api.requests)
+}
@@ -0,0 +1,329 @@
+package monzo
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for NewBreaker.
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultCacheSize        = 1000
+	defaultMaxCacheAge      = 15 * time.Minute
+)
+
+// BreakerState is where a Breaker is in its cycle.
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen turns requests away without trying Monzo.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets one request through to see if Monzo is back.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ErrCircuitOpen is returned for requests the Breaker turns away with
+// nothing cached to serve instead. Check for it with IsCircuitOpen.
+var ErrCircuitOpen = errors.New("monzo: circuit breaker is open, Monzo looks to be down")
+
+// IsCircuitOpen reports whether err is, or wraps, ErrCircuitOpen.
+func IsCircuitOpen(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return err == ErrCircuitOpen
+}
+
+// staleWarning marks responses served from the Breaker's cache.
+const staleWarning = `110 - "Response is Stale"`
+
+// Breaker is an http.RoundTripper that stops sending requests to Monzo after
+// FailureThreshold failures in a row, so that callers fail fast rather than
+// pile up behind a Monzo outage. After OpenTimeout one request is let through
+// to test the water, and if it works the breaker closes again.
+//
+// Failures are network errors and server errors. While Monzo is failing, GET
+// requests are answered with the last successful response for the same
+// token and URL, if there is one no older than MaxCacheAge, marked with a
+// Warning header.
+type Breaker struct {
+	Next             http.RoundTripper
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	// CacheSize is how many responses are kept for serving while Monzo is
+	// failing.
+	CacheSize int
+	// MaxCacheAge is how long a response is kept for. Balances and
+	// transactions go out of date, and shouldn't linger in memory either.
+	MaxCacheAge time.Duration
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	cache    map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// BreakerStatus is a snapshot of a Breaker, for health checks.
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	CachedResponses     int          `json:"cached_responses"`
+}
+
+// NewBreaker wraps next in a closed Breaker.
+func NewBreaker(next http.RoundTripper) *Breaker {
+	return &Breaker{
+		Next:             next,
+		FailureThreshold: defaultFailureThreshold,
+		OpenTimeout:      defaultOpenTimeout,
+		CacheSize:        defaultCacheSize,
+		MaxCacheAge:      defaultMaxCacheAge,
+	}
+}
+
+// Status reports the breaker's state.
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pruneCache()
+	status := BreakerStatus{
+		State:               b.currentState(),
+		ConsecutiveFailures: b.failures,
+		CachedResponses:     len(b.cache),
+	}
+	if status.State != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func (b *Breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Header.Get("Authorization") + " " + req.URL.String()
+
+	probe, ok := b.allow()
+	if !ok {
+		if resp := b.cached(req, key); resp != nil {
+			return resp, nil
+		}
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := b.Next.RoundTrip(req)
+	failed := err != nil || resp.StatusCode >= 500
+	// Giving up on a request isn't Monzo's fault
+	if err != nil && req.Context().Err() != nil {
+		b.release(probe)
+		return resp, err
+	}
+	b.record(probe, failed)
+
+	if failed {
+		if cached := b.cached(req, key); cached != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return cached, nil
+		}
+		return resp, err
+	}
+
+	if req.Method == "GET" && resp.StatusCode/100 == 2 {
+		return b.remember(key, resp)
+	}
+	return resp, nil
+}
+
+// allow reports whether a request may go to Monzo, and whether it is the
+// probe of a half-open breaker.
+func (b *Breaker) allow() (probe bool, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerClosed:
+		return false, true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// currentState works out the state, moving from open to half-open once
+// OpenTimeout has passed. b.mu must be held.
+func (b *Breaker) currentState() BreakerState {
+	if b.state == "" {
+		b.state = BreakerClosed
+	}
+	if b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.OpenTimeout {
+		b.state = BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if probe || b.failures >= b.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = b.clock()
+	}
+}
+
+// release lets another request probe after one that didn't finish.
+func (b *Breaker) release(probe bool) {
+	if !probe {
+		return
+	}
+
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// remember keeps a copy of a successful response for serving later.
+func (b *Breaker) remember(key string, resp *http.Response) (*http.Response, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cache == nil {
+		b.cache = map[string]cachedResponse{}
+	}
+	if _, ok := b.cache[key]; !ok && len(b.cache) >= b.CacheSize {
+		b.pruneCache()
+	}
+	if _, ok := b.cache[key]; !ok && len(b.cache) >= b.CacheSize {
+		// Make room by dropping whichever entry the map offers first
+		for old := range b.cache {
+			delete(b.cache, old)
+			break
+		}
+	}
+	if b.CacheSize > 0 {
+		b.cache[key] = cachedResponse{status: resp.StatusCode, header: cloneHeader(resp.Header), body: body, stored: b.clock()}
+	}
+	return resp, nil
+}
+
+// Forget drops every response cached for accessToken, such as when its user
+// logs out.
+func (b *Breaker) Forget(accessToken string) {
+	prefix := "Bearer " + accessToken + " "
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key := range b.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(b.cache, key)
+		}
+	}
+}
+
+// pruneCache drops every response older than MaxCacheAge. b.mu must be held.
+func (b *Breaker) pruneCache() {
+	for key, entry := range b.cache {
+		if b.tooOld(entry) {
+			delete(b.cache, key)
+		}
+	}
+}
+
+func (b *Breaker) tooOld(entry cachedResponse) bool {
+	return b.MaxCacheAge > 0 && b.clock().Sub(entry.stored) >= b.MaxCacheAge
+}
+
+// cached builds a response from the last one remembered for key, or returns
+// nil if there isn't one.
+func (b *Breaker) cached(req *http.Request, key string) *http.Response {
+	if req.Method != "GET" {
+		return nil
+	}
+
+	b.mu.Lock()
+	entry, ok := b.cache[key]
+	if ok && b.tooOld(entry) {
+		delete(b.cache, key)
+		ok = false
+	}
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	header := cloneHeader(entry.header)
+	header.Set("Warning", staleWarning)
+
+	return &http.Response{
+		Status:        http.StatusText(entry.status),
+		StatusCode:    entry.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(entry.body)),
+		ContentLength: int64(len(entry.body)),
+		Request:       req,
+	}
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := http.Header{}
+	for name, values := range header {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+func (b *Breaker) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
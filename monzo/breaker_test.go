@@ -0,0 +1,193 @@
+package monzo
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyTransport fails while down is set, and otherwise answers with body.
+type flakyTransport struct {
+	mu       sync.Mutex
+	down     bool
+	status   int
+	body     string
+	requests int
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+	if f.down {
+		if f.status != 0 {
+			return &http.Response{StatusCode: f.status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(f.body))}, nil
+}
+
+func testBreaker(next http.RoundTripper) (*Breaker, *time.Time) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := NewBreaker(next)
+	breaker.FailureThreshold = 2
+	breaker.OpenTimeout = time.Minute
+	breaker.now = func() time.Time { return now }
+	return breaker, &now
+}
+
+func breakerClient(breaker *Breaker, token string) *Client {
+	client := NewClient("http://monzo.invalid", StaticToken(token))
+	client.HTTPClient = &http.Client{Transport: breaker}
+	return client
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	monzo := &flakyTransport{down: true}
+	breaker, now := testBreaker(monzo)
+	client := breakerClient(breaker, "access")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.WhoAmI(ctx)
+		assert.Error(t, err)
+		assert.False(t, IsCircuitOpen(err))
+	}
+	assert.Equal(t, BreakerOpen, breaker.Status().State)
+
+	_, err := client.WhoAmI(ctx)
+	assert.True(t, IsCircuitOpen(err))
+	assert.Equal(t, 2, monzo.requests, "An open breaker shouldn't call Monzo")
+
+	// The probe after the timeout fails, so the breaker opens again
+	*now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, breaker.Status().State)
+	_, err = client.WhoAmI(ctx)
+	assert.False(t, IsCircuitOpen(err))
+	assert.Equal(t, BreakerOpen, breaker.Status().State)
+	assert.Equal(t, 3, monzo.requests)
+
+	// This time Monzo is back
+	monzo.down = false
+	monzo.body = `{"authenticated":true}`
+	*now = now.Add(time.Minute)
+	whoami, err := client.WhoAmI(ctx)
+	assert.NoError(t, err)
+	assert.True(t, whoami.Authenticated)
+	assert.Equal(t, BreakerStatus{State: BreakerClosed, CachedResponses: 1}, breaker.Status())
+}
+
+func TestBreakerCountsServerErrorsNotClientErrors(t *testing.T) {
+	monzo := &flakyTransport{down: true, status: http.StatusNotFound}
+	breaker, _ := testBreaker(monzo)
+	client := breakerClient(breaker, "access")
+
+	for i := 0; i < 3; i++ {
+		client.WhoAmI(context.Background())
+	}
+	assert.Equal(t, BreakerClosed, breaker.Status().State)
+
+	monzo.status = http.StatusBadGateway
+	for i := 0; i < 2; i++ {
+		client.WhoAmI(context.Background())
+	}
+	assert.Equal(t, BreakerOpen, breaker.Status().State)
+}
+
+func TestBreakerServesLastKnownData(t *testing.T) {
+	monzo := &flakyTransport{body: `{"balance":5000,"currency":"GBP"}`}
+	breaker, _ := testBreaker(monzo)
+	alice := breakerClient(breaker, "alice")
+	bob := breakerClient(breaker, "bob")
+	ctx := context.Background()
+
+	_, err := alice.Balance(ctx, "acc_1")
+	assert.NoError(t, err)
+
+	monzo.down = true
+	for i := 0; i < 3; i++ {
+		balance, err := alice.Balance(ctx, "acc_1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5000), balance.Balance)
+	}
+	assert.Equal(t, BreakerOpen, breaker.Status().State)
+
+	// Nothing is cached for another user or another account
+	_, err = bob.Balance(ctx, "acc_1")
+	assert.True(t, IsCircuitOpen(err))
+	_, err = alice.Balance(ctx, "acc_2")
+	assert.True(t, IsCircuitOpen(err))
+	// And nothing that changes anything is answered from the cache
+	_, err = alice.Deposit(ctx, "pot_1", "acc_1", 100, "dedupe")
+	assert.True(t, IsCircuitOpen(err))
+}
+
+func TestBreakerCacheMarksStaleResponses(t *testing.T) {
+	monzo := &flakyTransport{body: `{}`}
+	breaker, _ := testBreaker(monzo)
+
+	req, _ := http.NewRequest("GET", "http://monzo.invalid/accounts", nil)
+	resp, err := breaker.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Warning"))
+
+	monzo.down = true
+	resp, err = breaker.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, staleWarning, resp.Header.Get("Warning"))
+}
+
+func TestBreakerStopsServingOldResponses(t *testing.T) {
+	monzo := &flakyTransport{body: `{"balance":5000,"currency":"GBP"}`}
+	breaker, now := testBreaker(monzo)
+	breaker.MaxCacheAge = 10 * time.Minute
+	client := breakerClient(breaker, "alice")
+	ctx := context.Background()
+
+	_, err := client.Balance(ctx, "acc_1")
+	assert.NoError(t, err)
+
+	monzo.down = true
+	*now = now.Add(10*time.Minute - time.Second)
+	_, err = client.Balance(ctx, "acc_1")
+	assert.NoError(t, err)
+
+	*now = now.Add(time.Second)
+	_, err = client.Balance(ctx, "acc_1")
+	assert.Error(t, err)
+	assert.Equal(t, 0, breaker.Status().CachedResponses)
+}
+
+func TestBreakerForgetsTokens(t *testing.T) {
+	monzo := &flakyTransport{body: `{"balance":5000,"currency":"GBP"}`}
+	breaker, _ := testBreaker(monzo)
+	alice := breakerClient(breaker, "alice")
+	bob := breakerClient(breaker, "bob")
+	ctx := context.Background()
+
+	for _, client := range []*Client{alice, bob} {
+		_, err := client.Balance(ctx, "acc_1")
+		assert.NoError(t, err)
+		_, err = client.Balance(ctx, "acc_2")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 4, breaker.Status().CachedResponses)
+
+	breaker.Forget("alice")
+	assert.Equal(t, 2, breaker.Status().CachedResponses)
+
+	monzo.down = true
+	_, err := alice.Balance(ctx, "acc_1")
+	assert.Error(t, err)
+	_, err = bob.Balance(ctx, "acc_1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,162 @@
+// Package monzo is a client for the parts of the Monzo API askmonzo uses.
+package monzo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultBaseURL is the Monzo production API.
+const DefaultBaseURL = "https://api.monzo.com"
+
+// TokenSource supplies the access token sent with each request.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same access token.
+type StaticToken string
+
+// Token returns t.
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Client talks to the Monzo API on behalf of whoever Tokens belongs to.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Tokens     TokenSource
+}
+
+// NewClient returns a Client for the API at baseURL. tokens may be nil for a
+// client that only exchanges OAuth tokens. Every Client made this way shares
+// one Transport.
+func NewClient(baseURL string, tokens TokenSource) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: sharedHTTPClient,
+		Tokens:     tokens,
+	}
+}
+
+// WithTokens returns a copy of c that authenticates with tokens.
+func (c *Client) WithTokens(tokens TokenSource) *Client {
+	clone := *c
+	clone.Tokens = tokens
+	return &clone
+}
+
+// APIError is an error response from the Monzo API.
+type APIError struct {
+	StatusCode int                    `json:"-"`
+	Code       string                 `json:"code"`
+	Message    string                 `json:"message"`
+	Params     map[string]interface{} `json:"params"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("monzo: %d %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("monzo: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// newAPIError builds an APIError from a failed response body, falling back
+// to the HTTP status text if the body isn't Monzo's error JSON.
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{}
+	if json.Unmarshal(body, apiErr) != nil || apiErr.Code == "" {
+		apiErr.Code = http.StatusText(statusCode)
+	}
+	apiErr.StatusCode = statusCode
+	return apiErr
+}
+
+// newRequest builds an authenticated request for path, which may carry a
+// query string. A non-nil form is sent as the url-encoded body.
+func (c *Client) newRequest(ctx context.Context, method, path string, form url.Values) (*http.Request, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if c.Tokens != nil {
+		token, err := c.Tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// do sends req and decodes a successful JSON response into out, which may be
+// nil. Failed responses come back as an *APIError.
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return newAPIError(resp.StatusCode, body)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// callJSON is call with body sent as JSON instead of a form.
+func (c *Client) callJSON(ctx context.Context, method, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, method, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, out)
+}
+
+// call is newRequest followed by do.
+func (c *Client) call(ctx context.Context, method, path string, form url.Values, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, form)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
@@ -0,0 +1,57 @@
+package monzo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient is NewClient without the shared Transport, so that tests
+// don't wait on each other's rate limits.
+func newTestClient(baseURL string, tokens TokenSource) *Client {
+	client := NewClient(baseURL, tokens)
+	client.HTTPClient = &http.Client{}
+	return client
+}
+
+func TestWhoAmI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/ping/whoami", r.URL.Path)
+		assert.Equal(t, "Bearer access", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"authenticated":true,"client_id":"client","user_id":"user_1"}`))
+	}))
+	defer server.Close()
+
+	whoami, err := newTestClient(server.URL+"/", StaticToken("access")).WhoAmI(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &WhoAmI{Authenticated: true, ClientID: "client", UserID: "user_1"}, whoami)
+}
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping/whoami" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"unauthorized.bad_access_token","message":"expired","params":{"client_id":"client"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>bad gateway</html>"))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("access"))
+
+	_, err := client.WhoAmI(context.Background())
+	assert.Equal(t, &APIError{
+		StatusCode: 401,
+		Code:       "unauthorized.bad_access_token",
+		Message:    "expired",
+		Params:     map[string]interface{}{"client_id": "client"},
+	}, err)
+
+	err = client.Logout(context.Background())
+	assert.Equal(t, &APIError{StatusCode: 502, Code: "Bad Gateway"}, err)
+}
@@ -0,0 +1,55 @@
+package monzo
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// FeedItem is a basic item for the user's Monzo feed. Title and ImageURL are
+// required. Colours are hex, such as #FCF1EE.
+type FeedItem struct {
+	Title    string
+	Body     string
+	ImageURL string
+	// URL is opened when the user taps the item.
+	URL string
+
+	BackgroundColor string
+	TitleColor      string
+	BodyColor       string
+}
+
+// ErrIncompleteFeedItem is returned by CreateFeedItem for an item without a
+// title or image.
+var ErrIncompleteFeedItem = errors.New("monzo: feed items need a title and an image URL")
+
+// CreateFeedItem puts item into the feed of the account with the given ID.
+func (c *Client) CreateFeedItem(ctx context.Context, accountID string, item FeedItem) error {
+	if item.Title == "" || item.ImageURL == "" {
+		return ErrIncompleteFeedItem
+	}
+
+	form := url.Values{}
+	form.Set("account_id", accountID)
+	form.Set("type", "basic")
+	if item.URL != "" {
+		form.Set("url", item.URL)
+	}
+
+	params := map[string]string{
+		"title":            item.Title,
+		"body":             item.Body,
+		"image_url":        item.ImageURL,
+		"background_color": item.BackgroundColor,
+		"title_color":      item.TitleColor,
+		"body_color":       item.BodyColor,
+	}
+	for name, value := range params {
+		if value != "" {
+			form.Set("params["+name+"]", value)
+		}
+	}
+
+	return c.call(ctx, "POST", "/feed", form, nil)
+}
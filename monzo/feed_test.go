@@ -0,0 +1,50 @@
+package monzo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateFeedItem(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/feed", r.URL.Path)
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	err := newTestClient(server.URL, StaticToken("access")).CreateFeedItem(context.Background(), "acc_1", FeedItem{
+		Title:           "Hello",
+		Body:            "From askmonzo",
+		ImageURL:        "https://askmonzo.example.com/icon.png",
+		URL:             "https://askmonzo.example.com/",
+		BackgroundColor: "#FCF1EE",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"account_id":               {"acc_1"},
+		"type":                     {"basic"},
+		"url":                      {"https://askmonzo.example.com/"},
+		"params[title]":            {"Hello"},
+		"params[body]":             {"From askmonzo"},
+		"params[image_url]":        {"https://askmonzo.example.com/icon.png"},
+		"params[background_color]": {"#FCF1EE"},
+	}, form)
+}
+
+func TestCreateFeedItemNeedsTitleAndImage(t *testing.T) {
+	client := newTestClient("http://monzo.invalid", StaticToken("access"))
+
+	err := client.CreateFeedItem(context.Background(), "acc_1", FeedItem{Title: "Hello"})
+	assert.Equal(t, ErrIncompleteFeedItem, err)
+	err = client.CreateFeedItem(context.Background(), "acc_1", FeedItem{ImageURL: "https://askmonzo.example.com/icon.png"})
+	assert.Equal(t, ErrIncompleteFeedItem, err)
+}
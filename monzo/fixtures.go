@@ -0,0 +1,483 @@
+package monzo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fixture is a conversation with Monzo saved by a Recorder, for a Replayer to
+// play back in tests without a network or a real bank account.
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one request and the response Monzo gave to it.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a scrubbed request. URL is only the path and query, so
+// that a fixture replays against any base URL.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Body   FixtureBody `json:"body,omitempty"`
+}
+
+// RecordedResponse is a scrubbed response. Only the headers in
+// recordedHeaders are kept.
+type RecordedResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       FixtureBody `json:"body,omitempty"`
+}
+
+// FixtureBody is a request or response body. JSON bodies are kept as JSON so
+// that fixtures are easy to read and edit, and anything else as a string.
+type FixtureBody json.RawMessage
+
+// MarshalJSON writes the body as it is, since it is always valid JSON.
+func (b FixtureBody) MarshalJSON() ([]byte, error) {
+	if len(b) == 0 {
+		return []byte("null"), nil
+	}
+	return b, nil
+}
+
+// UnmarshalJSON keeps the body as it is in the fixture.
+func (b *FixtureBody) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*b = nil
+		return nil
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// bytes is the body as it went over the wire.
+func (b FixtureBody) bytes() []byte {
+	var text string
+	if json.Unmarshal(b, &text) == nil {
+		return []byte(text)
+	}
+	return b
+}
+
+// key is the body in a form that compares equal however it was indented.
+func (b FixtureBody) key() string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, b) != nil {
+		return string(b)
+	}
+	return compact.String()
+}
+
+// recordedHeaders are the only response headers kept in fixtures. Everything
+// else is either noise or, like Set-Cookie, not to be written down.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// Scrubber blanks out secrets and personal details before anything is
+// written to a fixture. A Replayer scrubs the requests it is sent the same
+// way, so that tests can use any token they like.
+type Scrubber struct {
+	// Fields maps keys in JSON bodies, forms and query strings to what their
+	// values are replaced with, wherever they appear.
+	Fields map[string]string
+	// FormFields is like Fields but only for forms and query strings, for
+	// names like "code" that mean something else in Monzo's JSON.
+	FormFields map[string]string
+	// Paths maps where values sit in JSON documents, as the keys leading to
+	// them joined by dots with array indices left out, to what the whole
+	// value is replaced with. A path matches the end of a value's path, so
+	// "counterparty" catches every transaction's counterparty while
+	// "merchant.name" is left alone. Empty values are kept as they are.
+	Paths map[string]interface{}
+	// TransferFields is like Fields but only for objects with a counterparty,
+	// which are bank transfers, where the description and notes are whatever
+	// the other side wrote.
+	TransferFields map[string]string
+	// Replace maps any other text to its stand-in, such as a real user or
+	// account ID to a made up one.
+	Replace map[string]string
+}
+
+// NewScrubber returns a Scrubber for tokens, client secrets, account holders'
+// names, account numbers and who bank transfers were to or from.
+func NewScrubber() *Scrubber {
+	return &Scrubber{
+		Fields: map[string]string{
+			"access_token":         "scrubbed_access_token",
+			"refresh_token":        "scrubbed_refresh_token",
+			"client_secret":        "scrubbed_client_secret",
+			"preferred_name":       "Jane Doe",
+			"preferred_first_name": "Jane",
+			"legal_name":           "Jane Doe",
+			"first_name":           "Jane",
+			"last_name":            "Doe",
+			"account_number":       "12345678",
+			"sort_code":            "040004",
+		},
+		FormFields: map[string]string{
+			"code":          "scrubbed_code",
+			"code_verifier": "scrubbed_code_verifier",
+		},
+		Paths: map[string]interface{}{
+			"counterparty": map[string]interface{}{
+				"name": "John Smith",
+			},
+		},
+		TransferFields: map[string]string{
+			"description": "Transfer",
+			"notes":       "",
+		},
+		Replace: map[string]string{},
+	}
+}
+
+// replace applies Replace to text, longest matches first.
+func (s *Scrubber) replace(text string) string {
+	if len(s.Replace) == 0 {
+		return text
+	}
+
+	olds := make([]string, 0, len(s.Replace))
+	for old := range s.Replace {
+		if old != "" {
+			olds = append(olds, old)
+		}
+	}
+	sort.Slice(olds, func(i, j int) bool { return len(olds[i]) > len(olds[j]) })
+
+	pairs := make([]string, 0, 2*len(olds))
+	for _, old := range olds {
+		pairs = append(pairs, old, s.Replace[old])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+func (s *Scrubber) formField(name string) (string, bool) {
+	if value, ok := s.Fields[name]; ok {
+		return value, true
+	}
+	value, ok := s.FormFields[name]
+	return value, ok
+}
+
+// values scrubs a form or query string, which comes out sorted by key.
+func (s *Scrubber) values(encoded string) string {
+	values, err := url.ParseQuery(s.replace(encoded))
+	if err != nil {
+		return s.replace(encoded)
+	}
+	for name := range values {
+		if value, ok := s.formField(name); ok {
+			for i := range values[name] {
+				values[name][i] = value
+			}
+		}
+	}
+	return values.Encode()
+}
+
+// json scrubs every object in a decoded JSON document.
+func (s *Scrubber) json(doc interface{}) interface{} {
+	return s.jsonAt("", doc)
+}
+
+// jsonAt scrubs doc, found at path.
+func (s *Scrubber) jsonAt(path string, doc interface{}) interface{} {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		transfer := !isEmptyJSON(doc["counterparty"])
+		for key, value := range doc {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if replacement, ok := s.path(keyPath); ok && !isEmptyJSON(value) {
+				doc[key] = replacement
+				continue
+			}
+			if _, isString := value.(string); isString {
+				if replacement, ok := s.Fields[key]; ok {
+					doc[key] = replacement
+					continue
+				}
+				if replacement, ok := s.TransferFields[key]; ok && transfer {
+					doc[key] = replacement
+					continue
+				}
+			}
+			doc[key] = s.jsonAt(keyPath, value)
+		}
+	case []interface{}:
+		for i, value := range doc {
+			doc[i] = s.jsonAt(path, value)
+		}
+	}
+	return doc
+}
+
+// path finds the replacement for the value at the JSON path given.
+func (s *Scrubber) path(path string) (interface{}, bool) {
+	for match, replacement := range s.Paths {
+		if path == match || strings.HasSuffix(path, "."+match) {
+			return replacement, true
+		}
+	}
+	return nil, false
+}
+
+func isEmptyJSON(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case map[string]interface{}:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+// URL scrubs the path and query of u.
+func (s *Scrubber) URL(u *url.URL) string {
+	path := s.replace(u.EscapedPath())
+	if u.RawQuery == "" {
+		return path
+	}
+	return path + "?" + s.values(u.RawQuery)
+}
+
+// Body scrubs a body sent with the given Content-Type.
+func (s *Scrubber) Body(contentType string, body []byte) FixtureBody {
+	if len(body) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		return stringBody(s.values(string(body)))
+	}
+
+	var doc interface{}
+	if json.Unmarshal([]byte(s.replace(string(body))), &doc) == nil {
+		if _, isString := doc.(string); !isString {
+			scrubbed, err := json.Marshal(s.json(doc))
+			if err == nil {
+				return scrubbed
+			}
+		}
+	}
+	return stringBody(s.replace(string(body)))
+}
+
+func stringBody(text string) FixtureBody {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(text)
+	return bytes.TrimSpace(body.Bytes())
+}
+
+// recordRequest reads and scrubs req, leaving its body to be read again.
+func (s *Scrubber) recordRequest(req *http.Request) (RecordedRequest, error) {
+	recorded := RecordedRequest{Method: req.Method, URL: s.URL(req.URL)}
+	if req.Body == nil {
+		return recorded, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return recorded, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorded.Body = s.Body(req.Header.Get("Content-Type"), body)
+	return recorded, nil
+}
+
+// Recorder is an http.RoundTripper that passes requests on to Next and keeps
+// a scrubbed copy of each one and its response, to be written out with Save.
+type Recorder struct {
+	Next     http.RoundTripper
+	Scrubber *Scrubber
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// NewRecorder returns a Recorder in front of next that scrubs with
+// NewScrubber.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	return &Recorder{Next: next, Scrubber: NewScrubber()}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := r.Scrubber.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	response := RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{},
+		Body:       r.Scrubber.Body(resp.Header.Get("Content-Type"), body),
+	}
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			response.Header.Set(name, value)
+		}
+	}
+
+	r.mu.Lock()
+	r.fixture.Interactions = append(r.fixture.Interactions, Interaction{Request: request, Response: response})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// Save writes everything recorded so far to the file at path.
+func (r *Recorder) Save(path string) error {
+	// Forms and URLs are easier to read without & escaped
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	r.mu.Lock()
+	err := encoder.Encode(r.fixture)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data.Bytes(), 0644)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a Fixture
+// instead of sending them anywhere. Requests are matched on their method,
+// path, query and body once scrubbed, and the same request made several times
+// gets each of its recorded responses in turn, then the last one again.
+type Replayer struct {
+	Scrubber *Scrubber
+
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     []int
+}
+
+// NewReplayer returns a Replayer for fixture that scrubs with NewScrubber.
+func NewReplayer(fixture Fixture) *Replayer {
+	return &Replayer{
+		Scrubber:     NewScrubber(),
+		interactions: fixture.Interactions,
+		replayed:     make([]int, len(fixture.Interactions)),
+	}
+}
+
+// LoadReplayer reads the fixture at path, as written by Recorder.Save.
+func LoadReplayer(path string) (*Replayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		return nil, fmt.Errorf("monzo: reading fixture %s: %s", path, err)
+	}
+	return NewReplayer(fixture), nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := r.Scrubber.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, interaction := range r.interactions {
+		recorded := interaction.Request
+		if recorded.Method != request.Method || recorded.URL != request.URL || recorded.Body.key() != request.Body.key() {
+			continue
+		}
+		if r.replayed[i] == 0 {
+			match = i
+			break
+		}
+		match = i
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("monzo: no recorded response for %s %s", request.Method, request.URL)
+	}
+	r.replayed[match]++
+
+	recorded := r.interactions[match].Response
+	header := cloneHeader(recorded.Header)
+	body := recorded.Body.bytes()
+	return &http.Response{
+		Status:        strconv.Itoa(recorded.StatusCode) + " " + http.StatusText(recorded.StatusCode),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Unreplayed lists the recorded requests nothing has asked for, so that
+// tests can check they covered the whole fixture.
+func (r *Replayer) Unreplayed() []RecordedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unreplayed []RecordedRequest
+	for i, interaction := range r.interactions {
+		if r.replayed[i] == 0 {
+			unreplayed = append(unreplayed, interaction.Request)
+		}
+	}
+	return unreplayed
+}
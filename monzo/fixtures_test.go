@@ -0,0 +1,281 @@
+package monzo
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var recordFixtures = flag.Bool("record", false, "record testdata fixtures against Monzo instead of replaying them")
+
+// The made up IDs fixtures use for the user and account they were recorded
+// with.
+const (
+	fixtureUserID    = "user_00009fixtureUser0001"
+	fixtureAccountID = "acc_00009fixtureAccount01"
+)
+
+// fixtureClient returns a Client that replays testdata/<name>.json.
+//
+// With -record it calls MONZO_API_URL, or Monzo itself, as MONZO_ACCESS_TOKEN
+// instead and rewrites the fixture once the test is done. MONZO_USER_ID and
+// MONZO_ACCOUNT_ID are swapped for the made up IDs above. Recorded data is
+// whatever is in the account, so tests may need their expectations updated.
+func fixtureClient(t *testing.T, name string) (*Client, func()) {
+	path := filepath.Join("testdata", name+".json")
+
+	if !*recordFixtures {
+		replayer, err := LoadReplayer(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := newTestClient(DefaultBaseURL, StaticToken("access"))
+		client.HTTPClient.Transport = replayer
+		return client, func() {
+			assert.Empty(t, replayer.Unreplayed(), "Every recorded request should be made")
+		}
+	}
+
+	baseURL := os.Getenv("MONZO_API_URL")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	recorder := NewRecorder(NewTransport())
+	recorder.Scrubber.Replace[os.Getenv("MONZO_USER_ID")] = fixtureUserID
+	recorder.Scrubber.Replace[os.Getenv("MONZO_ACCOUNT_ID")] = fixtureAccountID
+
+	client := NewClient(baseURL, StaticToken(os.Getenv("MONZO_ACCESS_TOKEN")))
+	client.HTTPClient = &http.Client{Transport: recorder}
+	return client, func() {
+		assert.NoError(t, recorder.Save(path))
+	}
+}
+
+// fixtureAccount is the account fixtures are recorded against.
+func fixtureAccount() string {
+	if *recordFixtures {
+		return os.Getenv("MONZO_ACCOUNT_ID")
+	}
+	return fixtureAccountID
+}
+
+func TestFixtureAccounts(t *testing.T) {
+	client, done := fixtureClient(t, "accounts")
+	defer done()
+	ctx := context.Background()
+
+	accounts, err := client.Accounts(ctx, "")
+	assert.NoError(t, err)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, fixtureAccount(), accounts[0].ID)
+		assert.Equal(t, AccountTypeRetail, accounts[0].Type)
+		assert.Equal(t, "GBP", accounts[0].Currency)
+		assert.Equal(t, time.Date(2017, 11, 1, 9, 31, 2, 154000000, time.UTC), accounts[0].Created)
+	}
+
+	accounts, err = client.Accounts(ctx, AccountTypeRetailJoint)
+	assert.NoError(t, err)
+	assert.Empty(t, accounts)
+
+	balance, err := client.Balance(ctx, fixtureAccount())
+	assert.NoError(t, err)
+	assert.Equal(t, &Balance{Balance: 12345, TotalBalance: 45678, Currency: "GBP", SpendToday: -1520}, balance)
+}
+
+func TestFixtureTransactions(t *testing.T) {
+	client, done := fixtureClient(t, "transactions")
+	defer done()
+
+	it := client.Transactions(context.Background(), TransactionsQuery{
+		AccountID:      fixtureAccount(),
+		Since:          time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		Before:         time.Date(2018, 1, 8, 0, 0, 0, 0, time.UTC),
+		PageSize:       2,
+		ExpandMerchant: true,
+	})
+
+	var transactions []Transaction
+	for it.Next() {
+		transactions = append(transactions, it.Transaction())
+	}
+	assert.NoError(t, it.Err())
+
+	if assert.Len(t, transactions, 5) {
+		assert.Equal(t, "tx_00009fixtureTx0000001", transactions[0].ID)
+		assert.Equal(t, int64(10000), transactions[0].Amount)
+		assert.Nil(t, transactions[0].Merchant, "Top ups have no merchant")
+
+		assert.Equal(t, "tx_00009fixtureTx0000002", transactions[1].ID)
+		assert.Equal(t, int64(-385), transactions[1].Amount)
+		assert.Equal(t, "Pret A Manger", transactions[1].Merchant.Name)
+		assert.Equal(t, "eating_out", transactions[1].Category)
+
+		assert.Equal(t, "tx_00009fixtureTx0000005", transactions[4].ID)
+	}
+}
+
+// scrubbedMonzo answers with the kind of things that must never end up in a
+// fixture.
+func scrubbedMonzo(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"access_token":"real-access","refresh_token":"real-refresh","expires_in":21600,"user_id":"user_real"}`))
+		case "/accounts":
+			w.Write([]byte(`{"accounts":[{"id":"acc_real","description":"user_real","account_number":"31415926","sort_code":"271828","owners":[{"user_id":"user_real","preferred_name":"Alice Example"}]}]}`))
+		case "/transactions":
+			w.Write([]byte(`{"transactions":[` +
+				`{"id":"tx_transfer","amount":-50000,"description":"BOB EXAMPLE","notes":"Rent for 12 Real Street","counterparty":{"name":"Bob Example","user_id":"user_bob","account_number":"27182818","sort_code":"314159"},"merchant":null},` +
+				`{"id":"tx_card","amount":-385,"description":"PRET A MANGER","notes":"","counterparty":{},"merchant":{"id":"merch_1","name":"Pret A Manger"}}` +
+				`]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","message":"Nothing here"}`))
+		}
+	}))
+}
+
+func TestRecorderScrubsFixtures(t *testing.T) {
+	server := scrubbedMonzo(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "testdata", "scrubbed.json")
+
+	recorder := NewRecorder(http.DefaultTransport)
+	recorder.Scrubber.Replace["user_real"] = "user_fake"
+	client := newTestClient(server.URL, StaticToken("real-access"))
+	client.HTTPClient.Transport = recorder
+	ctx := context.Background()
+
+	_, err = client.ExchangeToken(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"client"},
+		"client_secret": {"real-secret"},
+		"code":          {"real-code"},
+	})
+	assert.NoError(t, err)
+	_, err = client.Accounts(ctx, "")
+	assert.NoError(t, err)
+	_, err = client.Transaction(ctx, "tx_missing")
+	assert.Error(t, err)
+	assert.NoError(t, recorder.Save(path))
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	for _, secret := range []string{"real-access", "real-refresh", "real-secret", "real-code", "user_real", "31415926", "271828", "Alice", "secret-cookie"} {
+		assert.NotContains(t, string(data), secret)
+	}
+
+	// The fixture replays for whoever asks, with the same tokens scrubbed
+	replayer, err := LoadReplayer(path)
+	assert.NoError(t, err)
+	client = newTestClient("http://monzo.invalid", StaticToken("another-access"))
+	client.HTTPClient.Transport = replayer
+
+	token, err := client.ExchangeToken(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"client"},
+		"client_secret": {"another-secret"},
+		"code":          {"another-code"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &Token{AccessToken: "scrubbed_access_token", RefreshToken: "scrubbed_refresh_token", ExpiresIn: 21600, UserID: "user_fake"}, token)
+
+	accounts, err := client.Accounts(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Account{{ID: "acc_real", Description: "user_fake"}}, accounts)
+
+	_, err = client.Transaction(ctx, "tx_missing")
+	assert.Equal(t, &APIError{StatusCode: 404, Code: "not_found", Message: "Nothing here"}, err)
+	assert.Empty(t, replayer.Unreplayed())
+}
+
+func TestRecorderScrubsTransfers(t *testing.T) {
+	server := scrubbedMonzo(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "testdata", "transfers.json")
+
+	recorder := NewRecorder(http.DefaultTransport)
+	client := newTestClient(server.URL, StaticToken("real-access"))
+	client.HTTPClient.Transport = recorder
+
+	it := client.Transactions(context.Background(), TransactionsQuery{AccountID: "acc_real"})
+	for it.Next() {
+	}
+	assert.NoError(t, it.Err())
+	assert.NoError(t, recorder.Save(path))
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	for _, secret := range []string{"Bob", "BOB", "user_bob", "27182818", "314159", "Real Street"} {
+		assert.NotContains(t, string(data), secret)
+	}
+	assert.Contains(t, string(data), "John Smith")
+
+	replayer, err := LoadReplayer(path)
+	assert.NoError(t, err)
+	client = newTestClient("http://monzo.invalid", StaticToken("access"))
+	client.HTTPClient.Transport = replayer
+
+	var transactions []Transaction
+	it = client.Transactions(context.Background(), TransactionsQuery{AccountID: "acc_real"})
+	for it.Next() {
+		transactions = append(transactions, it.Transaction())
+	}
+	assert.NoError(t, it.Err())
+	if assert.Len(t, transactions, 2) {
+		assert.Equal(t, "Transfer", transactions[0].Description)
+		assert.Empty(t, transactions[0].Notes)
+
+		// Card payments have an empty counterparty and keep their details
+		assert.Equal(t, "PRET A MANGER", transactions[1].Description)
+		assert.Equal(t, "Pret A Manger", transactions[1].Merchant.Name)
+	}
+}
+
+func TestReplayerPlaysResponsesInTurn(t *testing.T) {
+	replayer := NewReplayer(Fixture{Interactions: []Interaction{
+		{
+			Request:  RecordedRequest{Method: "GET", URL: "/ping/whoami"},
+			Response: RecordedResponse{StatusCode: 200, Body: FixtureBody(`{"authenticated":false}`)},
+		},
+		{
+			Request:  RecordedRequest{Method: "GET", URL: "/ping/whoami"},
+			Response: RecordedResponse{StatusCode: 200, Body: FixtureBody(`{"authenticated":true}`)},
+		},
+	}})
+	client := newTestClient("http://monzo.invalid", StaticToken("access"))
+	client.HTTPClient.Transport = replayer
+
+	for _, expected := range []bool{false, true, true} {
+		whoami, err := client.WhoAmI(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, whoami.Authenticated)
+	}
+
+	_, err := client.Accounts(context.Background(), "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no recorded response for GET /accounts")
+	}
+}
@@ -0,0 +1,89 @@
+package monzo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Token is what Monzo's token endpoint hands out.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	ClientID     string `json:"client_id"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	UserID       string `json:"user_id"`
+}
+
+// OAuthError is what Monzo's token endpoint sends back when it turns a
+// request down.
+type OAuthError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("oauth error %d %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("oauth error %d %s: %s", e.StatusCode, e.Code, e.Description)
+}
+
+// IsInvalidGrant reports whether err says the code or refresh token sent is
+// no good any more, so retrying with it is pointless.
+func IsInvalidGrant(err error) bool {
+	oauthErr, ok := err.(*OAuthError)
+	return ok && oauthErr.Code == "invalid_grant"
+}
+
+// newOAuthError builds an OAuthError from a failed token response body,
+// falling back to the HTTP status text if the body isn't an OAuth error.
+func newOAuthError(statusCode int, body []byte) *OAuthError {
+	oauthErr := &OAuthError{}
+	if json.Unmarshal(body, oauthErr) != nil || oauthErr.Code == "" {
+		oauthErr.Code = http.StatusText(statusCode)
+	}
+	oauthErr.StatusCode = statusCode
+	return oauthErr
+}
+
+// ExchangeToken posts params, such as an authorization code or refresh token
+// grant, to the token endpoint. A refusal comes back as an *OAuthError.
+func (c *Client) ExchangeToken(ctx context.Context, params url.Values) (*Token, error) {
+	req, err := c.WithTokens(nil).newRequest(ctx, "POST", "/oauth2/token", params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, newOAuthError(resp.StatusCode, body)
+	}
+
+	var token Token
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Logout revokes the client's access token and its refresh token.
+func (c *Client) Logout(ctx context.Context) error {
+	return c.call(ctx, "POST", "/oauth2/logout", nil, nil)
+}
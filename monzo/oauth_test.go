@@ -0,0 +1,45 @@
+package monzo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOAuthError(t *testing.T) {
+	err := newOAuthError(http.StatusBadRequest, []byte(`{"error":"invalid_grant","error_description":"expired"}`))
+	assert.Equal(t, &OAuthError{StatusCode: 400, Code: "invalid_grant", Description: "expired"}, err)
+	assert.True(t, IsInvalidGrant(err))
+
+	err = newOAuthError(http.StatusBadGateway, []byte("<html>bad gateway</html>"))
+	assert.Equal(t, &OAuthError{StatusCode: 502, Code: "Bad Gateway"}, err)
+	assert.False(t, IsInvalidGrant(err))
+}
+
+func TestExchangeToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/oauth2/token", r.URL.Path)
+		assert.Equal(t, "", r.Header.Get("Authorization"))
+
+		if r.PostFormValue("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":60,"user_id":"user_1"}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("not-sent"))
+
+	token, err := client.ExchangeToken(context.Background(), url.Values{"code": {"good"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &Token{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 60, UserID: "user_1"}, token)
+
+	_, err = client.ExchangeToken(context.Background(), url.Values{"code": {"bad"}})
+	assert.True(t, IsInvalidGrant(err))
+}
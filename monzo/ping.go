@@ -0,0 +1,20 @@
+package monzo
+
+import "context"
+
+// WhoAmI describes the token a request was made with.
+type WhoAmI struct {
+	Authenticated bool   `json:"authenticated"`
+	ClientID      string `json:"client_id"`
+	UserID        string `json:"user_id"`
+}
+
+// WhoAmI checks the client's access token.
+func (c *Client) WhoAmI(ctx context.Context) (*WhoAmI, error) {
+	var whoami WhoAmI
+	err := c.call(ctx, "GET", "/ping/whoami", nil, &whoami)
+	if err != nil {
+		return nil, err
+	}
+	return &whoami, nil
+}
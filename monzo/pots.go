@@ -0,0 +1,74 @@
+package monzo
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Pot is a pot of money set aside from a current account.
+type Pot struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Style    string    `json:"style"`
+	Balance  int64     `json:"balance"`
+	Currency string    `json:"currency"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Deleted  bool      `json:"deleted"`
+}
+
+// ErrNoDedupeID is returned by Deposit and Withdraw when called without a
+// dedupe ID.
+var ErrNoDedupeID = errors.New("monzo: moving money needs a dedupe ID")
+
+// ErrBadAmount is returned by Deposit and Withdraw for amounts that aren't a
+// positive number of minor units.
+var ErrBadAmount = errors.New("monzo: amount must be a positive number of minor units")
+
+// Pots lists the pots belonging to the current account with the given ID.
+func (c *Client) Pots(ctx context.Context, currentAccountID string) ([]Pot, error) {
+	var resp struct {
+		Pots []Pot `json:"pots"`
+	}
+	path := "/pots?" + url.Values{"current_account_id": {currentAccountID}}.Encode()
+	err := c.call(ctx, "GET", path, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Pots, nil
+}
+
+// Deposit moves amount minor units from the source account into the pot,
+// returning the pot as it is afterwards. Monzo only acts once on each
+// dedupeID, so a retry must reuse the ID of the attempt it repeats.
+func (c *Client) Deposit(ctx context.Context, potID, sourceAccountID string, amount int64, dedupeID string) (*Pot, error) {
+	return c.movePotMoney(ctx, potID, "deposit", url.Values{"source_account_id": {sourceAccountID}}, amount, dedupeID)
+}
+
+// Withdraw moves amount minor units out of the pot into the destination
+// account, returning the pot as it is afterwards. dedupeID works as it does
+// for Deposit.
+func (c *Client) Withdraw(ctx context.Context, potID, destinationAccountID string, amount int64, dedupeID string) (*Pot, error) {
+	return c.movePotMoney(ctx, potID, "withdraw", url.Values{"destination_account_id": {destinationAccountID}}, amount, dedupeID)
+}
+
+func (c *Client) movePotMoney(ctx context.Context, potID, action string, form url.Values, amount int64, dedupeID string) (*Pot, error) {
+	if amount <= 0 {
+		return nil, ErrBadAmount
+	}
+	if dedupeID == "" {
+		return nil, ErrNoDedupeID
+	}
+	form.Set("amount", strconv.FormatInt(amount, 10))
+	form.Set("dedupe_id", dedupeID)
+
+	var pot Pot
+	err := c.call(ctx, "PUT", "/pots/"+url.PathEscape(potID)+"/"+action, form, &pot)
+	if err != nil {
+		return nil, err
+	}
+	return &pot, nil
+}
@@ -0,0 +1,65 @@
+package monzo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/pots", r.URL.Path)
+		assert.Equal(t, "acc_1", r.URL.Query().Get("current_account_id"))
+		w.Write([]byte(`{"pots":[{"id":"pot_1","name":"Savings","balance":1000,"currency":"GBP"}]}`))
+	}))
+	defer server.Close()
+
+	pots, err := newTestClient(server.URL, StaticToken("access")).Pots(context.Background(), "acc_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Pot{{ID: "pot_1", Name: "Savings", Balance: 1000, Currency: "GBP"}}, pots)
+}
+
+func TestDepositAndWithdraw(t *testing.T) {
+	var forms []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		r.ParseForm()
+		forms = append(forms, map[string]string{
+			"path":        r.URL.Path,
+			"source":      r.Form.Get("source_account_id"),
+			"destination": r.Form.Get("destination_account_id"),
+			"amount":      r.Form.Get("amount"),
+			"dedupe_id":   r.Form.Get("dedupe_id"),
+		})
+		w.Write([]byte(`{"id":"pot_1","balance":1500}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("access"))
+
+	pot, err := client.Deposit(context.Background(), "pot_1", "acc_1", 500, "dedupe-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), pot.Balance)
+
+	_, err = client.Withdraw(context.Background(), "pot_1", "acc_1", 250, "dedupe-2")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []map[string]string{
+		{"path": "/pots/pot_1/deposit", "source": "acc_1", "destination": "", "amount": "500", "dedupe_id": "dedupe-1"},
+		{"path": "/pots/pot_1/withdraw", "source": "", "destination": "acc_1", "amount": "250", "dedupe_id": "dedupe-2"},
+	}, forms)
+}
+
+func TestMovingPotMoneyIsValidated(t *testing.T) {
+	client := newTestClient("http://monzo.invalid", StaticToken("access"))
+
+	_, err := client.Deposit(context.Background(), "pot_1", "acc_1", 0, "dedupe-1")
+	assert.Equal(t, ErrBadAmount, err)
+	_, err = client.Withdraw(context.Background(), "pot_1", "acc_1", -5, "dedupe-1")
+	assert.Equal(t, ErrBadAmount, err)
+	_, err = client.Deposit(context.Background(), "pot_1", "acc_1", 5, "")
+	assert.Equal(t, ErrNoDedupeID, err)
+}
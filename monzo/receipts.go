@@ -0,0 +1,91 @@
+package monzo
+
+import (
+	"context"
+	"net/url"
+)
+
+// Receipt is an itemised receipt for a transaction. ExternalID is our own ID
+// for it, which Monzo uses to replace the receipt when it is sent again.
+// Amounts are in minor units, and Total must match the transaction's amount.
+type Receipt struct {
+	ID            string           `json:"id,omitempty"`
+	TransactionID string           `json:"transaction_id"`
+	ExternalID    string           `json:"external_id"`
+	Total         int64            `json:"total"`
+	Currency      string           `json:"currency"`
+	Items         []ReceiptItem    `json:"items"`
+	Taxes         []ReceiptTax     `json:"taxes,omitempty"`
+	Payments      []ReceiptPayment `json:"payments,omitempty"`
+	Merchant      *ReceiptMerchant `json:"merchant,omitempty"`
+}
+
+// ReceiptItem is a line on a receipt. Amount is for the whole line, not for
+// each unit.
+type ReceiptItem struct {
+	Description string        `json:"description"`
+	Amount      int64         `json:"amount"`
+	Currency    string        `json:"currency"`
+	Quantity    float64       `json:"quantity,omitempty"`
+	Unit        string        `json:"unit,omitempty"`
+	Tax         int64         `json:"tax,omitempty"`
+	SubItems    []ReceiptItem `json:"sub_items,omitempty"`
+}
+
+// ReceiptTax is tax charged on a receipt, such as VAT.
+type ReceiptTax struct {
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	TaxNumber   string `json:"tax_number,omitempty"`
+}
+
+// Payment types for ReceiptPayment.
+const (
+	PaymentTypeCard     = "card"
+	PaymentTypeCash     = "cash"
+	PaymentTypeGiftCard = "gift_card"
+)
+
+// ReceiptPayment is how a receipt was paid.
+type ReceiptPayment struct {
+	Type         string `json:"type"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	LastFour     string `json:"last_four,omitempty"`
+	GiftCardType string `json:"gift_card_type,omitempty"`
+}
+
+// ReceiptMerchant is who issued a receipt.
+type ReceiptMerchant struct {
+	Name          string `json:"name,omitempty"`
+	Online        bool   `json:"online,omitempty"`
+	Phone         string `json:"phone,omitempty"`
+	Email         string `json:"email,omitempty"`
+	StoreName     string `json:"store_name,omitempty"`
+	StoreAddress  string `json:"store_address,omitempty"`
+	StorePostcode string `json:"store_postcode,omitempty"`
+}
+
+// CreateReceipt attaches receipt to its transaction, replacing any receipt
+// already sent with the same external ID.
+func (c *Client) CreateReceipt(ctx context.Context, receipt *Receipt) error {
+	return c.callJSON(ctx, "PUT", "/transaction-receipts", receipt, nil)
+}
+
+// Receipt fetches the receipt with the given external ID.
+func (c *Client) Receipt(ctx context.Context, externalID string) (*Receipt, error) {
+	var resp struct {
+		Receipt Receipt `json:"receipt"`
+	}
+	err := c.call(ctx, "GET", "/transaction-receipts?"+url.Values{"external_id": {externalID}}.Encode(), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Receipt, nil
+}
+
+// DeleteReceipt removes the receipt with the given external ID.
+func (c *Client) DeleteReceipt(ctx context.Context, externalID string) error {
+	return c.call(ctx, "DELETE", "/transaction-receipts?"+url.Values{"external_id": {externalID}}.Encode(), nil, nil)
+}
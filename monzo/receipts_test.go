@@ -0,0 +1,68 @@
+package monzo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceipts(t *testing.T) {
+	receipts := map[string]json.RawMessage{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/transaction-receipts", r.URL.Path)
+		assert.Equal(t, "Bearer access", r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case "PUT":
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			var receipt Receipt
+			var raw json.RawMessage
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&raw))
+			assert.NoError(t, json.Unmarshal(raw, &receipt))
+			receipts[receipt.ExternalID] = raw
+			w.Write([]byte(`{}`))
+		case "GET":
+			receipt, ok := receipts[r.URL.Query().Get("external_id")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"code":"not_found"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]json.RawMessage{"receipt": receipt})
+		case "DELETE":
+			delete(receipts, r.URL.Query().Get("external_id"))
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("access"))
+	ctx := context.Background()
+
+	receipt := &Receipt{
+		TransactionID: "tx_1",
+		ExternalID:    "receipt_1",
+		Total:         350,
+		Currency:      "GBP",
+		Items: []ReceiptItem{
+			{Description: "Coffee", Amount: 250, Currency: "GBP", Quantity: 1},
+			{Description: "Croissant", Amount: 100, Currency: "GBP", Quantity: 1},
+		},
+		Taxes:    []ReceiptTax{{Description: "VAT", Amount: 58, Currency: "GBP"}},
+		Payments: []ReceiptPayment{{Type: PaymentTypeCard, Amount: 350, Currency: "GBP"}},
+		Merchant: &ReceiptMerchant{Name: "Pret"},
+	}
+	assert.NoError(t, client.CreateReceipt(ctx, receipt))
+
+	fetched, err := client.Receipt(ctx, "receipt_1")
+	assert.NoError(t, err)
+	assert.Equal(t, receipt, fetched)
+
+	assert.NoError(t, client.DeleteReceipt(ctx, "receipt_1"))
+	_, err = client.Receipt(ctx, "receipt_1")
+	assert.Equal(t, &APIError{StatusCode: 404, Code: "not_found"}, err)
+}
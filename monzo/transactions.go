@@ -0,0 +1,200 @@
+package monzo
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// defaultPageSize is how many transactions are asked for at a time, the most
+// Monzo hands out per page.
+const defaultPageSize = 100
+
+// Transaction is a single movement of money on an account. Amounts are in
+// minor units and negative for money going out.
+type Transaction struct {
+	ID          string            `json:"id"`
+	AccountID   string            `json:"account_id"`
+	Amount      int64             `json:"amount"`
+	Currency    string            `json:"currency"`
+	Created     time.Time         `json:"created"`
+	Settled     string            `json:"settled"`
+	Description string            `json:"description"`
+	Category    string            `json:"category"`
+	Notes       string            `json:"notes"`
+	Metadata    map[string]string `json:"metadata"`
+	// Merchant only has its ID set unless the merchant was expanded.
+	Merchant    *Merchant    `json:"merchant"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Merchant is who a card payment was made to.
+type Merchant struct {
+	ID       string `json:"id"`
+	GroupID  string `json:"group_id"`
+	Name     string `json:"name"`
+	Logo     string `json:"logo"`
+	Emoji    string `json:"emoji"`
+	Category string `json:"category"`
+}
+
+// UnmarshalJSON reads either an expanded merchant or just its ID, which is
+// what Monzo sends unless asked to expand it.
+func (m *Merchant) UnmarshalJSON(data []byte) error {
+	var id string
+	if json.Unmarshal(data, &id) == nil {
+		*m = Merchant{ID: id}
+		return nil
+	}
+
+	type merchant Merchant
+	return json.Unmarshal(data, (*merchant)(m))
+}
+
+// TransactionsQuery picks the transactions a TransactionIterator walks.
+type TransactionsQuery struct {
+	AccountID string
+	// Since and Before bound the transactions by creation time. Since is
+	// inclusive and Before exclusive. Either may be zero for no bound.
+	Since  time.Time
+	Before time.Time
+	// PageSize is how many transactions are fetched per request, defaulting
+	// to the most Monzo allows.
+	PageSize int
+	// ExpandMerchant fills in each transaction's Merchant.
+	ExpandMerchant bool
+}
+
+// TransactionIterator walks every transaction matching a query, oldest first,
+// fetching pages as it goes. Call Next until it returns false, then check Err.
+type TransactionIterator struct {
+	client *Client
+	ctx    context.Context
+	query  TransactionsQuery
+
+	page    []Transaction
+	current Transaction
+	// cursor is the ID of the last transaction seen, which the next page
+	// starts after.
+	cursor string
+	last   bool
+	err    error
+}
+
+// Transactions returns an iterator over the transactions matching query.
+func (c *Client) Transactions(ctx context.Context, query TransactionsQuery) *TransactionIterator {
+	if query.PageSize <= 0 {
+		query.PageSize = defaultPageSize
+	}
+	return &TransactionIterator{
+		client: c,
+		ctx:    ctx,
+		query:  query,
+	}
+}
+
+// Next moves on to the next transaction, reporting false once there are no
+// more or fetching a page failed.
+func (it *TransactionIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.last || it.err != nil {
+			return false
+		}
+		it.err = it.fetch()
+	}
+
+	it.current = it.page[0]
+	it.page = it.page[1:]
+
+	if !it.query.Before.IsZero() && !it.current.Created.Before(it.query.Before) {
+		it.page = nil
+		it.last = true
+		return false
+	}
+	return true
+}
+
+// Transaction is the transaction Next moved on to.
+func (it *TransactionIterator) Transaction() Transaction {
+	return it.current
+}
+
+// Err is the error that stopped the iterator, if any.
+func (it *TransactionIterator) Err() error {
+	return it.err
+}
+
+func (it *TransactionIterator) fetch() error {
+	params := url.Values{}
+	params.Set("account_id", it.query.AccountID)
+	params.Set("limit", strconv.Itoa(it.query.PageSize))
+	if it.cursor != "" {
+		params.Set("since", it.cursor)
+	} else if !it.query.Since.IsZero() {
+		params.Set("since", it.query.Since.UTC().Format(time.RFC3339))
+	}
+	if !it.query.Before.IsZero() {
+		params.Set("before", it.query.Before.UTC().Format(time.RFC3339))
+	}
+	if it.query.ExpandMerchant {
+		params.Set("expand[]", "merchant")
+	}
+
+	var resp struct {
+		Transactions []Transaction `json:"transactions"`
+	}
+	err := it.client.call(it.ctx, "GET", "/transactions?"+params.Encode(), nil, &resp)
+	if err != nil {
+		return err
+	}
+
+	it.page = resp.Transactions
+	if len(it.page) < it.query.PageSize {
+		it.last = true
+	}
+	if len(it.page) > 0 {
+		cursor := it.page[len(it.page)-1].ID
+		// A server that hands back the same page again would keep us here
+		// forever
+		if cursor == it.cursor {
+			it.page = nil
+			it.last = true
+			return nil
+		}
+		it.cursor = cursor
+	}
+	return nil
+}
+
+// Transaction fetches the transaction with the given ID.
+func (c *Client) Transaction(ctx context.Context, transactionID string) (*Transaction, error) {
+	var resp struct {
+		Transaction Transaction `json:"transaction"`
+	}
+	err := c.call(ctx, "GET", "/transactions/"+url.PathEscape(transactionID), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Transaction, nil
+}
+
+// AnnotateTransaction sets metadata on the transaction, leaving keys not in
+// metadata alone. An empty value removes its key. The key "notes" holds the
+// notes the user sees in the app.
+func (c *Client) AnnotateTransaction(ctx context.Context, transactionID string, metadata map[string]string) (*Transaction, error) {
+	form := url.Values{}
+	for key, value := range metadata {
+		form.Set("metadata["+key+"]", value)
+	}
+
+	var resp struct {
+		Transaction Transaction `json:"transaction"`
+	}
+	err := c.call(ctx, "PATCH", "/transactions/"+url.PathEscape(transactionID), form, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Transaction, nil
+}
@@ -0,0 +1,168 @@
+package monzo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// transactionsServer serves count transactions, one a day from the start of
+// 2018, paging them the way Monzo does. It records each request's query.
+func transactionsServer(count int, queries *[]map[string]string) *httptest.Server {
+	start := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	all := make([]Transaction, count)
+	for i := range all {
+		all[i] = Transaction{
+			ID:       fmt.Sprintf("tx_%02d", i),
+			Amount:   -100,
+			Created:  start.AddDate(0, 0, i),
+			Merchant: &Merchant{ID: fmt.Sprintf("merch_%02d", i)},
+		}
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		*queries = append(*queries, map[string]string{
+			"since":   query.Get("since"),
+			"before":  query.Get("before"),
+			"expand":  query.Get("expand[]"),
+			"account": query.Get("account_id"),
+		})
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		page := []interface{}{}
+		for i, tx := range all {
+			if since := query.Get("since"); since != "" {
+				if sinceTime, err := time.Parse(time.RFC3339, since); err == nil {
+					if tx.Created.Before(sinceTime) {
+						continue
+					}
+				} else if since >= tx.ID {
+					continue
+				}
+			}
+			if before, err := time.Parse(time.RFC3339, query.Get("before")); err == nil && !tx.Created.Before(before) {
+				continue
+			}
+			if len(page) == limit {
+				break
+			}
+
+			if query.Get("expand[]") == "merchant" {
+				page = append(page, tx)
+				continue
+			}
+			page = append(page, map[string]interface{}{
+				"id":       tx.ID,
+				"amount":   tx.Amount,
+				"created":  tx.Created,
+				"merchant": all[i].Merchant.ID,
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"transactions": page})
+	}))
+}
+
+func TestTransactionsWalksEveryPage(t *testing.T) {
+	var queries []map[string]string
+	server := transactionsServer(7, &queries)
+	defer server.Close()
+
+	it := newTestClient(server.URL, StaticToken("access")).Transactions(context.Background(), TransactionsQuery{
+		AccountID: "acc_1",
+		PageSize:  3,
+	})
+
+	var ids []string
+	for it.Next() {
+		tx := it.Transaction()
+		ids = append(ids, tx.ID)
+		assert.Equal(t, "merch_"+tx.ID[3:], tx.Merchant.ID)
+		assert.Empty(t, tx.Merchant.Name)
+	}
+	assert.NoError(t, it.Err())
+
+	assert.Equal(t, []string{"tx_00", "tx_01", "tx_02", "tx_03", "tx_04", "tx_05", "tx_06"}, ids)
+	assert.Len(t, queries, 3)
+	assert.Equal(t, "", queries[0]["since"])
+	assert.Equal(t, "tx_02", queries[1]["since"])
+	assert.Equal(t, "tx_05", queries[2]["since"])
+	assert.Equal(t, "acc_1", queries[2]["account"])
+}
+
+func TestTransactionsStopsAtTimeBound(t *testing.T) {
+	var queries []map[string]string
+	server := transactionsServer(20, &queries)
+	defer server.Close()
+
+	it := newTestClient(server.URL, StaticToken("access")).Transactions(context.Background(), TransactionsQuery{
+		AccountID:      "acc_1",
+		Since:          time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC),
+		Before:         time.Date(2018, 1, 9, 0, 0, 0, 0, time.UTC),
+		PageSize:       2,
+		ExpandMerchant: true,
+	})
+
+	var ids []string
+	for it.Next() {
+		tx := it.Transaction()
+		ids = append(ids, tx.ID)
+		assert.Equal(t, "merch_"+tx.ID[3:], tx.Merchant.ID)
+	}
+	assert.NoError(t, it.Err())
+
+	assert.Equal(t, []string{"tx_02", "tx_03", "tx_04", "tx_05", "tx_06", "tx_07"}, ids)
+	assert.Equal(t, "2018-01-03T00:00:00Z", queries[0]["since"])
+	for _, query := range queries {
+		assert.Equal(t, "2018-01-09T00:00:00Z", query["before"])
+		assert.Equal(t, "merchant", query["expand"])
+	}
+}
+
+func TestTransactionsReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":"forbidden.verification_required"}`))
+	}))
+	defer server.Close()
+
+	it := newTestClient(server.URL, StaticToken("access")).Transactions(context.Background(), TransactionsQuery{AccountID: "acc_1"})
+	assert.False(t, it.Next())
+	assert.Equal(t, &APIError{StatusCode: 403, Code: "forbidden.verification_required"}, it.Err())
+}
+
+func TestAnnotateTransaction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/transactions/tx_1", r.URL.Path)
+		if r.Method == "GET" {
+			w.Write([]byte(`{"transaction":{"id":"tx_1","notes":"Lunch","metadata":{"notes":"Lunch"}}}`))
+			return
+		}
+
+		assert.Equal(t, "PATCH", r.Method)
+		r.ParseForm()
+		assert.Equal(t, "Lunch with Sam", r.PostForm.Get("metadata[notes]"))
+		assert.Equal(t, "", r.PostForm.Get("metadata[old]"))
+		_, removed := r.PostForm["metadata[old]"]
+		assert.True(t, removed, "An empty value should be sent to remove the key")
+		w.Write([]byte(`{"transaction":{"id":"tx_1","notes":"Lunch with Sam","metadata":{"notes":"Lunch with Sam"}}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("access"))
+
+	tx, err := client.Transaction(context.Background(), "tx_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Lunch", tx.Notes)
+
+	tx, err = client.AnnotateTransaction(context.Background(), "tx_1", map[string]string{"notes": "Lunch with Sam", "old": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, "Lunch with Sam", tx.Metadata["notes"])
+}
@@ -0,0 +1,301 @@
+package monzo
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for NewTransport and NewHTTPClient.
+const (
+	defaultMaxRetries    = 3
+	defaultBaseBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff    = 10 * time.Second
+	defaultMaxRetryAfter = time.Minute
+	// Monzo doesn't publish its limits, so stay well clear of them.
+	defaultRequestsPerSecond = 5
+	defaultBurst             = 10
+
+	defaultClientTimeout = 2 * time.Minute
+)
+
+// Transport is an http.RoundTripper for the Monzo API. It spaces out each
+// access token's requests, and retries requests Monzo turned away.
+//
+// A 429 is always retried, since Monzo did nothing with the request. Server
+// errors and network failures are only retried for idempotent methods, as
+// the request may have been acted on. Retries wait for as long as
+// Retry-After asks, or back off exponentially with jitter.
+type Transport struct {
+	// Base does the actual requests.
+	Base http.RoundTripper
+
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxRetryAfter is the longest Retry-After we wait for. Responses asking
+	// for longer are returned as they are.
+	MaxRetryAfter time.Duration
+
+	// RequestsPerSecond and Burst limit each access token's requests.
+	RequestsPerSecond float64
+	Burst             int
+
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func() float64
+
+	mu       sync.Mutex
+	limiters map[string]*tokenBucket
+}
+
+// NewTransport returns a Transport with sensible limits and timeouts.
+func NewTransport() *Transport {
+	return &Transport{
+		Base: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          100,
+		},
+		MaxRetries:        defaultMaxRetries,
+		BaseBackoff:       defaultBaseBackoff,
+		MaxBackoff:        defaultMaxBackoff,
+		MaxRetryAfter:     defaultMaxRetryAfter,
+		RequestsPerSecond: defaultRequestsPerSecond,
+		Burst:             defaultBurst,
+	}
+}
+
+// NewHTTPClient returns an http.Client that goes through transport, usually a
+// Transport or a Breaker around one, with an overall timeout on each request,
+// retries included.
+func NewHTTPClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   defaultClientTimeout,
+	}
+}
+
+// sharedHTTPClient is what every Client from NewClient uses, so that they
+// share connections and rate limits.
+var sharedHTTPClient = NewHTTPClient(NewTransport())
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		// RoundTrippers mustn't change the caller's request, so each attempt
+		// gets its own copy with a fresh body
+		attemptReq := req.WithContext(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		err := t.wait(ctx, req.Header.Get("Authorization"))
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base().RoundTrip(attemptReq)
+
+		delay, retry := t.retryDelay(req, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		err = t.pause(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay decides whether to retry after an attempt, and how long to wait
+// first.
+func (t *Transport) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= t.MaxRetries || req.Context().Err() != nil {
+		return 0, false
+	}
+	// A body that can't be read again can't be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	switch {
+	case err != nil:
+		if !isIdempotent(req.Method) {
+			return 0, false
+		}
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode >= 500 && isIdempotent(req.Method):
+	default:
+		return 0, false
+	}
+
+	if resp != nil {
+		if after, ok := t.retryAfter(resp); ok {
+			if after > t.MaxRetryAfter {
+				return 0, false
+			}
+			return after, true
+		}
+	}
+	return t.backoff(attempt), true
+}
+
+// retryAfter reads the Retry-After header, given in seconds or as a date.
+func (t *Transport) retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		after := date.Sub(t.clock())
+		if after < 0 {
+			after = 0
+		}
+		return after, true
+	}
+	return 0, false
+}
+
+// backoff doubles with each attempt up to MaxBackoff, and is then cut by a
+// random amount of up to half so that clients don't retry in step.
+func (t *Transport) backoff(attempt int) time.Duration {
+	d := time.Duration(float64(t.BaseBackoff) * math.Pow(2, float64(attempt)))
+	if d > t.MaxBackoff || d <= 0 {
+		d = t.MaxBackoff
+	}
+
+	jitter := rand.Float64
+	if t.jitter != nil {
+		jitter = t.jitter
+	}
+	return d/2 + time.Duration(jitter()*float64(d/2))
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) clock() time.Time {
+	if t.now == nil {
+		return time.Now()
+	}
+	return t.now()
+}
+
+func (t *Transport) pause(ctx context.Context, d time.Duration) error {
+	if t.sleep != nil {
+		return t.sleep(ctx, d)
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait holds the request back until the token's rate limit allows it.
+// Requests without a token, such as token exchanges, aren't limited.
+func (t *Transport) wait(ctx context.Context, token string) error {
+	if token == "" || t.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	if t.limiters == nil {
+		t.limiters = map[string]*tokenBucket{}
+	}
+	bucket, ok := t.limiters[token]
+	if !ok {
+		t.pruneLimiters()
+		bucket = &tokenBucket{tokens: float64(t.burst()), last: t.clock()}
+		t.limiters[token] = bucket
+	}
+	delay := bucket.take(t.clock(), t.RequestsPerSecond, float64(t.burst()))
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	return t.pause(ctx, delay)
+}
+
+// pruneLimiters forgets tokens that have been quiet long enough for their
+// bucket to fill up again, as they would start afresh anyway.
+func (t *Transport) pruneLimiters() {
+	full := time.Duration(float64(t.burst()) / t.RequestsPerSecond * float64(time.Second))
+	for token, bucket := range t.limiters {
+		if t.clock().Sub(bucket.last) > full {
+			delete(t.limiters, token)
+		}
+	}
+}
+
+func (t *Transport) burst() int {
+	if t.Burst < 1 {
+		return 1
+	}
+	return t.Burst
+}
+
+// tokenBucket refills at a steady rate up to a burst, and each request takes
+// one token from it.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take claims a token, returning how long to wait until it is there. Tokens
+// can be claimed ahead of time, leaving the bucket in debt, so that waiting
+// requests queue up in order.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
@@ -0,0 +1,221 @@
+package monzo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedServer answers with each of statuses in turn, then 200s. A status
+// of 429 comes with Retry-After set to retryAfter, if that isn't empty.
+type scriptedServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func newScriptedServer(retryAfter string, statuses ...int) *scriptedServer {
+	s := &scriptedServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		if status == http.StatusTooManyRequests && retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"authenticated":true}`))
+	}))
+	return s
+}
+
+func (s *scriptedServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.bodies)
+}
+
+// testTransport is a Transport that records the waits it would make instead
+// of sleeping, on a clock that moves on by as much.
+func testTransport() (*Transport, *[]time.Duration) {
+	var waits []time.Duration
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	transport := NewTransport()
+	transport.Base = http.DefaultTransport
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return nil
+	}
+	transport.jitter = func() float64 { return 1 }
+	return transport, &waits
+}
+
+func clientFor(server *scriptedServer, transport *Transport) *Client {
+	client := NewClient(server.URL, StaticToken("access"))
+	client.HTTPClient = NewHTTPClient(transport)
+	return client
+}
+
+func TestTransportHonoursRetryAfter(t *testing.T) {
+	server := newScriptedServer("2", http.StatusTooManyRequests, http.StatusTooManyRequests)
+	defer server.Close()
+	transport, waits := testTransport()
+
+	_, err := clientFor(server, transport).WhoAmI(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, server.requests())
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second}, *waits)
+}
+
+func TestTransportReadsRetryAfterDates(t *testing.T) {
+	transport, _ := testTransport()
+	resp := &http.Response{Header: http.Header{}}
+
+	resp.Header.Set("Retry-After", transport.clock().Add(30*time.Second).Format(http.TimeFormat))
+	after, ok := transport.retryAfter(resp)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, after)
+
+	resp.Header.Set("Retry-After", "soon")
+	_, ok = transport.retryAfter(resp)
+	assert.False(t, ok)
+}
+
+func TestTransportGivesUpOnLongRetryAfter(t *testing.T) {
+	server := newScriptedServer("3600", http.StatusTooManyRequests)
+	defer server.Close()
+	transport, waits := testTransport()
+
+	_, err := clientFor(server, transport).WhoAmI(context.Background())
+	assert.Equal(t, &APIError{StatusCode: 429, Code: "Too Many Requests"}, err)
+	assert.Equal(t, 1, server.requests())
+	assert.Empty(t, *waits)
+}
+
+func TestTransportBacksOffOnServerErrors(t *testing.T) {
+	server := newScriptedServer("", http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusServiceUnavailable)
+	defer server.Close()
+	transport, waits := testTransport()
+
+	_, err := clientFor(server, transport).WhoAmI(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, server.requests())
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}, *waits)
+}
+
+func TestTransportStopsAfterMaxRetries(t *testing.T) {
+	server := newScriptedServer("", 503, 503, 503, 503, 503)
+	defer server.Close()
+	transport, _ := testTransport()
+
+	_, err := clientFor(server, transport).WhoAmI(context.Background())
+	assert.Equal(t, &APIError{StatusCode: 503, Code: "Service Unavailable"}, err)
+	assert.Equal(t, 1+defaultMaxRetries, server.requests())
+}
+
+func TestTransportOnlyRetriesServerErrorsWhenIdempotent(t *testing.T) {
+	server := newScriptedServer("", http.StatusServiceUnavailable)
+	defer server.Close()
+	transport, _ := testTransport()
+
+	err := clientFor(server, transport).Logout(context.Background())
+	assert.Equal(t, &APIError{StatusCode: 503, Code: "Service Unavailable"}, err)
+	assert.Equal(t, 1, server.requests(), "A POST that may have been acted on must not be sent again")
+}
+
+func TestTransportRetriesRateLimitedPostsWithTheirBody(t *testing.T) {
+	server := newScriptedServer("1", http.StatusTooManyRequests)
+	defer server.Close()
+	transport, _ := testTransport()
+
+	err := clientFor(server, transport).CreateFeedItem(context.Background(), "acc_1", FeedItem{Title: "Hi", ImageURL: "https://askmonzo.example.com/icon.png"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, server.requests()) {
+		assert.Equal(t, server.bodies[0], server.bodies[1])
+		form, _ := url.ParseQuery(server.bodies[1])
+		assert.Equal(t, "Hi", form.Get("params[title]"))
+	}
+
+	server = newScriptedServer("1", http.StatusTooManyRequests)
+	defer server.Close()
+	err = clientFor(server, transport).CreateReceipt(context.Background(), &Receipt{ExternalID: "receipt_1"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, server.requests()) {
+		assert.True(t, strings.Contains(server.bodies[1], `"external_id":"receipt_1"`))
+	}
+}
+
+func TestTransportLeavesTheRequestAlone(t *testing.T) {
+	server := newScriptedServer("1", http.StatusTooManyRequests)
+	defer server.Close()
+	transport, _ := testTransport()
+
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader("body"))
+	assert.NoError(t, err)
+	body := req.Body
+
+	resp, err := transport.RoundTrip(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, 2, server.requests())
+	assert.Equal(t, []string{"body", "body"}, server.bodies)
+	assert.True(t, body == req.Body, "The request's body was replaced")
+}
+
+func TestTransportRateLimitsEachToken(t *testing.T) {
+	server := newScriptedServer("")
+	defer server.Close()
+	transport, waits := testTransport()
+	transport.RequestsPerSecond = 2
+	transport.Burst = 2
+
+	alice := clientFor(server, transport)
+	bob := alice.WithTokens(StaticToken("bob"))
+	for i := 0; i < 4; i++ {
+		_, err := alice.WhoAmI(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, *waits)
+
+	_, err := bob.WhoAmI(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, *waits, 2, "Each token should have a bucket of its own")
+}
+
+func TestTransportStopsWaitingWhenCancelled(t *testing.T) {
+	server := newScriptedServer("30", http.StatusTooManyRequests)
+	defer server.Close()
+
+	client := NewClient(server.URL, StaticToken("access"))
+	client.HTTPClient = NewHTTPClient(NewTransport())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.WhoAmI(ctx)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
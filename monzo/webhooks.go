@@ -0,0 +1,85 @@
+package monzo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// EventTransactionCreated is the webhook event sent for each new transaction.
+const EventTransactionCreated = "transaction.created"
+
+// Webhook is a URL Monzo sends an account's events to.
+type Webhook struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+	URL       string `json:"url"`
+}
+
+// WebhookEvent is the body Monzo posts to a webhook. Data depends on Type.
+type WebhookEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ParseWebhookEvent reads the body of a webhook request.
+func ParseWebhookEvent(body []byte) (*WebhookEvent, error) {
+	var event WebhookEvent
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, err
+	}
+	if event.Type == "" {
+		return nil, fmt.Errorf("monzo: webhook event has no type")
+	}
+	return &event, nil
+}
+
+// Transaction reads the transaction of a transaction.created event.
+func (e *WebhookEvent) Transaction() (*Transaction, error) {
+	if e.Type != EventTransactionCreated {
+		return nil, fmt.Errorf("monzo: %s events don't carry a transaction", e.Type)
+	}
+
+	var tx Transaction
+	err := json.Unmarshal(e.Data, &tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// RegisterWebhook asks Monzo to post the account's events to webhookURL.
+func (c *Client) RegisterWebhook(ctx context.Context, accountID, webhookURL string) (*Webhook, error) {
+	form := url.Values{}
+	form.Set("account_id", accountID)
+	form.Set("url", webhookURL)
+
+	var resp struct {
+		Webhook Webhook `json:"webhook"`
+	}
+	err := c.call(ctx, "POST", "/webhooks", form, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Webhook, nil
+}
+
+// Webhooks lists the webhooks registered for the account.
+func (c *Client) Webhooks(ctx context.Context, accountID string) ([]Webhook, error) {
+	var resp struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	path := "/webhooks?" + url.Values{"account_id": {accountID}}.Encode()
+	err := c.call(ctx, "GET", path, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Webhooks, nil
+}
+
+// DeleteWebhook stops Monzo posting to the webhook with the given ID.
+func (c *Client) DeleteWebhook(ctx context.Context, webhookID string) error {
+	return c.call(ctx, "DELETE", "/webhooks/"+url.PathEscape(webhookID), nil, nil)
+}
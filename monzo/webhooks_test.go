@@ -0,0 +1,76 @@
+package monzo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Form.Get("account_id")+" "+r.Form.Get("url"))
+
+		switch r.Method {
+		case "POST":
+			w.Write([]byte(`{"webhook":{"id":"webhook_1","account_id":"acc_1","url":"https://askmonzo.example.com/hook"}}`))
+		case "GET":
+			w.Write([]byte(`{"webhooks":[{"id":"webhook_1","account_id":"acc_1","url":"https://askmonzo.example.com/hook"}]}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, StaticToken("access"))
+	expected := Webhook{ID: "webhook_1", AccountID: "acc_1", URL: "https://askmonzo.example.com/hook"}
+
+	webhook, err := client.RegisterWebhook(context.Background(), "acc_1", "https://askmonzo.example.com/hook")
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, webhook)
+
+	webhooks, err := client.Webhooks(context.Background(), "acc_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Webhook{expected}, webhooks)
+
+	assert.NoError(t, client.DeleteWebhook(context.Background(), "webhook_1"))
+
+	assert.Equal(t, []string{
+		"POST /webhooks acc_1 https://askmonzo.example.com/hook",
+		"GET /webhooks acc_1 ",
+		"DELETE /webhooks/webhook_1  ",
+	}, requests)
+}
+
+func TestParseWebhookEvent(t *testing.T) {
+	event, err := ParseWebhookEvent([]byte(`{
+		"type": "transaction.created",
+		"data": {
+			"id": "tx_1",
+			"account_id": "acc_1",
+			"amount": -350,
+			"currency": "GBP",
+			"created": "2018-01-02T03:04:05Z",
+			"description": "PRET A MANGER",
+			"merchant": {"id": "merch_1", "name": "Pret A Manger", "emoji": "🥪"}
+		}
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, EventTransactionCreated, event.Type)
+
+	tx, err := event.Transaction()
+	assert.NoError(t, err)
+	assert.Equal(t, "tx_1", tx.ID)
+	assert.Equal(t, int64(-350), tx.Amount)
+	assert.Equal(t, "Pret A Manger", tx.Merchant.Name)
+
+	_, err = ParseWebhookEvent([]byte(`{"data":{}}`))
+	assert.Error(t, err)
+
+	_, err = (&WebhookEvent{Type: "account.updated"}).Transaction()
+	assert.Error(t, err)
+}
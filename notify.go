@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// Notifier puts messages into users' Monzo feeds.
+type Notifier interface {
+	// Notify renders the named notification template with data and posts
+	// it to the feed of the account with accountID. An empty accountID
+	// means the user's retail account.
+	Notify(ctx context.Context, userID, accountID, name string, data interface{}) error
+}
+
+// notificationTemplate is a feed item with text/template fields, which can
+// use the money function to format an amount in minor units and a currency.
+type notificationTemplate struct {
+	Title string
+	Body  string
+	URL   string
+
+	BackgroundColor string
+	TitleColor      string
+	BodyColor       string
+}
+
+// notificationTemplates are the notifications the server sends, by name.
+var notificationTemplates = map[string]notificationTemplate{
+	// alert takes a Title, a Body and optionally a URL.
+	"alert": {
+		Title: "{{.Title}}",
+		Body:  "{{.Body}}",
+		URL:   "{{.URL}}",
+	},
+}
+
+var errNoFeedImage = errors.New("no feed image URL configured, set FEED_IMAGE_URL or feed_image_url")
+
+// feedNotifier is the Notifier that talks to Monzo.
+type feedNotifier struct {
+	api       *monzo.Client
+	tokens    *tokenManager
+	imageURL  string
+	templates map[string]*feedTemplate
+}
+
+// feedTemplate is a parsed notificationTemplate.
+type feedTemplate struct {
+	title, body, url *template.Template
+	source           notificationTemplate
+}
+
+// newFeedNotifier parses templates. Every feed item shows the image at
+// imageURL, without which Monzo turns notifications down.
+func newFeedNotifier(api *monzo.Client, tokens *tokenManager, imageURL string, templates map[string]notificationTemplate) (*feedNotifier, error) {
+	notifier := &feedNotifier{
+		api:       api,
+		tokens:    tokens,
+		imageURL:  imageURL,
+		templates: map[string]*feedTemplate{},
+	}
+
+	for name, source := range templates {
+		parsed, err := parseNotificationTemplate(name, source)
+		if err != nil {
+			return nil, err
+		}
+		notifier.templates[name] = parsed
+	}
+	return notifier, nil
+}
+
+func (n *feedNotifier) Notify(ctx context.Context, userID, accountID, name string, data interface{}) error {
+	tmpl, ok := n.templates[name]
+	if !ok {
+		return fmt.Errorf("no notification template called %s", name)
+	}
+	if n.imageURL == "" {
+		return errNoFeedImage
+	}
+
+	item, err := tmpl.render(data)
+	if err != nil {
+		return err
+	}
+	item.ImageURL = n.imageURL
+
+	client := n.api.WithTokens(n.tokens.TokenSource(userID))
+	if accountID == "" {
+		accounts, err := client.Accounts(ctx, monzo.AccountTypeRetail)
+		if err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			if !account.Closed {
+				accountID = account.ID
+				break
+			}
+		}
+		if accountID == "" {
+			return fmt.Errorf("%s has no open retail account to notify", userID)
+		}
+	}
+
+	return client.CreateFeedItem(ctx, accountID, item)
+}
+
+func parseNotificationTemplate(name string, source notificationTemplate) (*feedTemplate, error) {
+	var err error
+	parse := func(field, text string) *template.Template {
+		tmpl := template.New(name + "." + field).Funcs(template.FuncMap{"money": formatMoney})
+		if err == nil {
+			tmpl, err = tmpl.Parse(text)
+		}
+		return tmpl
+	}
+
+	parsed := &feedTemplate{
+		title:  parse("title", source.Title),
+		body:   parse("body", source.Body),
+		url:    parse("url", source.URL),
+		source: source,
+	}
+	if err != nil {
+		return nil, fmt.Errorf("notification template %s: %s", name, err)
+	}
+	return parsed, nil
+}
+
+func (t *feedTemplate) render(data interface{}) (monzo.FeedItem, error) {
+	item := monzo.FeedItem{
+		BackgroundColor: t.source.BackgroundColor,
+		TitleColor:      t.source.TitleColor,
+		BodyColor:       t.source.BodyColor,
+	}
+
+	var err error
+	execute := func(tmpl *template.Template) string {
+		var buf bytes.Buffer
+		if err == nil {
+			err = tmpl.Execute(&buf, data)
+		}
+		return buf.String()
+	}
+	item.Title = execute(t.title)
+	item.Body = execute(t.body)
+	item.URL = execute(t.url)
+	return item, err
+}
+
+// currencySymbols are the currencies formatMoney writes with a symbol.
+var currencySymbols = map[string]string{
+	"GBP": "£",
+	"EUR": "€",
+	"USD": "$",
+}
+
+// formatMoney writes an amount in minor units, such as -150 GBP as -£1.50.
+func formatMoney(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	value := fmt.Sprintf("%d.%02d", amount/100, amount%100)
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + value
+	}
+	return sign + value + " " + currency
+}
+
+// feedAlert is the body of a request to post an alert, and the data of the
+// alert template.
+type feedAlert struct {
+	AccountID string `json:"account_id"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	URL       string `json:"url"`
+}
+
+// feedHandlerWrapper puts an alert into the user's own feed.
+func feedHandlerWrapper(notifier Notifier) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var alert feedAlert
+		err := json.NewDecoder(c.Request.Body).Decode(&alert)
+		if err != nil || alert.Title == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "Body must be JSON with at least a title",
+			})
+			return
+		}
+		if alert.URL != "" && !isAbsoluteURL(alert.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "url must be an absolute URL",
+			})
+			return
+		}
+
+		err = notifier.Notify(c.Request.Context(), getToken(c).UserID, alert.AccountID, "alert", alert)
+		if err == errNoFeedImage {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"Error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "posted to the feed",
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMoney(t *testing.T) {
+	for _, test := range []struct {
+		amount   int64
+		currency string
+		expected string
+	}{
+		{150, "GBP", "£1.50"},
+		{-150, "GBP", "-£1.50"},
+		{5, "EUR", "€0.05"},
+		{123456, "USD", "$1234.56"},
+		{-1000, "JPY", "-10.00 JPY"},
+	} {
+		assert.Equal(t, test.expected, formatMoney(test.amount, test.currency))
+	}
+}
+
+func TestNotifierRendersTemplates(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+
+	store := newMemoryTokenStore()
+	store.Save("user_alice", AuthResponse{UserID: "user_alice", AccessToken: "access-alice", AuthExpiryTimestamp: 1 << 40})
+	tokens := newTokenManager(store, nil)
+
+	notifier, err := newFeedNotifier(monzo.NewClient(fake.URL, nil), tokens, "https://askmonzo.example.com/icon.png", map[string]notificationTemplate{
+		"spent": {
+			Title:           "You spent {{money .Amount .Currency}}",
+			Body:            "at {{.Merchant}}",
+			BackgroundColor: "#FCF1EE",
+		},
+	})
+	assert.NoError(t, err)
+
+	err = notifier.Notify(context.Background(), "user_alice", "", "spent", map[string]interface{}{
+		"Amount":   int64(-250),
+		"Currency": "GBP",
+		"Merchant": "Pret",
+	})
+	assert.NoError(t, err)
+
+	if assert.Len(t, fake.feedItems, 1) {
+		item := fake.feedItems[0]
+		assert.Equal(t, "acc_alice", item.Get("account_id"))
+		assert.Equal(t, "You spent -£2.50", item.Get("params[title]"))
+		assert.Equal(t, "at Pret", item.Get("params[body]"))
+		assert.Equal(t, "#FCF1EE", item.Get("params[background_color]"))
+		assert.Equal(t, "https://askmonzo.example.com/icon.png", item.Get("params[image_url]"))
+	}
+
+	err = notifier.Notify(context.Background(), "user_alice", "", "missing", nil)
+	assert.Error(t, err)
+}
+
+func TestNotifierRejectsBadTemplates(t *testing.T) {
+	_, err := newFeedNotifier(nil, nil, "", map[string]notificationTemplate{
+		"broken": {Title: "{{.Title"},
+	})
+	assert.Error(t, err)
+}
+
+func TestFeedRoute(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	w := browser.post(t, "/api/feed", `{"title":"Hello"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Nothing can be posted without a feed image")
+
+	cfg := monzo.config()
+	cfg.FeedImageURL = "https://askmonzo.example.com/icon.png"
+	browser = &fakeBrowser{server: testServer(cfg)}
+	browser.login(t, "alice")
+
+	w = browser.post(t, "/api/feed", `{"body":"No title"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = browser.post(t, "/api/feed", `{"title":"Hello","body":"From askmonzo","url":"https://askmonzo.example.com/"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, monzo.feedItems, 1) {
+		assert.Equal(t, "Hello", monzo.feedItems[0].Get("params[title]"))
+		assert.Equal(t, "https://askmonzo.example.com/", monzo.feedItems[0].Get("url"))
+	}
+}
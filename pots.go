@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// potTransfer is the body of a deposit or withdrawal. Amount is in minor
+// units, pence for GBP. DedupeID may be left out, in which case one is made
+// up and returned, errors included; send it back when retrying so the money
+// only moves once. Callers that might not see the response at all, because of
+// a timeout say, should make up their own.
+type potTransfer struct {
+	AccountID string `json:"account_id"`
+	Amount    int64  `json:"amount"`
+	DedupeID  string `json:"dedupe_id"`
+}
+
+// potsHandlerWrapper lists the pots of the current account given in the
+// account_id query parameter.
+func potsHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		accountID := c.Query("account_id")
+		if accountID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "account_id is required",
+			})
+			return
+		}
+
+		pots, err := userClient(c, api).Pots(c.Request.Context(), accountID)
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		if pots == nil {
+			pots = []monzo.Pot{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"pots": pots,
+		})
+	}
+}
+
+// potDepositHandlerWrapper moves money from an account into a pot.
+func potDepositHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return potTransferHandler(api, func(ctx context.Context, client *monzo.Client, potID string, transfer potTransfer) (*monzo.Pot, error) {
+		return client.Deposit(ctx, potID, transfer.AccountID, transfer.Amount, transfer.DedupeID)
+	})
+}
+
+// potWithdrawHandlerWrapper moves money out of a pot into an account.
+func potWithdrawHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return potTransferHandler(api, func(ctx context.Context, client *monzo.Client, potID string, transfer potTransfer) (*monzo.Pot, error) {
+		return client.Withdraw(ctx, potID, transfer.AccountID, transfer.Amount, transfer.DedupeID)
+	})
+}
+
+// potTransferHandler validates a potTransfer, hands it to move and responds
+// with the pot's new balance.
+func potTransferHandler(api *monzo.Client, move func(ctx context.Context, client *monzo.Client, potID string, transfer potTransfer) (*monzo.Pot, error)) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var transfer potTransfer
+		err := json.NewDecoder(c.Request.Body).Decode(&transfer)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "Body must be JSON with account_id and a whole number amount in minor units",
+			})
+			return
+		}
+
+		if transfer.AccountID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "account_id is required",
+			})
+			return
+		}
+		if transfer.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": "amount must be a positive number of minor units, such as 150 for £1.50",
+			})
+			return
+		}
+		if transfer.DedupeID == "" {
+			transfer.DedupeID = randomToken()
+		}
+
+		pot, err := move(c.Request.Context(), userClient(c, api), c.Param("id"), transfer)
+		if err != nil {
+			// The money may have moved even so, so the caller needs the
+			// dedupe ID to retry safely
+			monzoErrorResponseWith(c, err, gin.H{
+				"dedupe_id": transfer.DedupeID,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"pot":       pot,
+			"balance":   pot.Balance,
+			"dedupe_id": transfer.DedupeID,
+		})
+	}
+}
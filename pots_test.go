@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPotTransfers(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	w := browser.get(t, "/api/pots?account_id=acc_alice")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"balance":1000`))
+
+	w = browser.put(t, "/api/pots/pot_1/deposit", `{"account_id":"acc_alice","amount":500}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deposit struct {
+		Balance  int64  `json:"balance"`
+		DedupeID string `json:"dedupe_id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &deposit))
+	assert.Equal(t, int64(1500), deposit.Balance)
+	assert.NotEmpty(t, deposit.DedupeID)
+
+	// Retrying with the dedupe ID we were given doesn't deposit again
+	w = browser.put(t, "/api/pots/pot_1/deposit", `{"account_id":"acc_alice","amount":500,"dedupe_id":"`+deposit.DedupeID+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"balance":1500`))
+
+	w = browser.put(t, "/api/pots/pot_1/withdraw", `{"account_id":"acc_alice","amount":200}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"balance":1300`))
+}
+
+func TestPotTransferRetryAfterFailure(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+	// The money moves, but every attempt, retries included, gets a 500
+	monzo.potFailures = 4
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	w := browser.put(t, "/api/pots/pot_1/deposit", `{"account_id":"acc_alice","amount":500}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	var failed struct {
+		DedupeID string `json:"dedupe_id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &failed))
+	assert.NotEmpty(t, failed.DedupeID)
+	assert.Equal(t, 0, monzo.potFailures)
+
+	w = browser.put(t, "/api/pots/pot_1/deposit", `{"account_id":"acc_alice","amount":500,"dedupe_id":"`+failed.DedupeID+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"balance":1500`), "The money should only move once: %s", w.Body.String())
+	assert.Equal(t, int64(1500), monzo.potBalance)
+}
+
+func TestPotTransfersValidateAmounts(t *testing.T) {
+	monzo := newFakeMonzo()
+	defer monzo.Close()
+
+	browser := &fakeBrowser{server: testServer(monzo.config())}
+	browser.login(t, "alice")
+
+	for _, body := range []string{
+		`{"account_id":"acc_alice"}`,
+		`{"account_id":"acc_alice","amount":0}`,
+		`{"account_id":"acc_alice","amount":-100}`,
+		`{"account_id":"acc_alice","amount":1.5}`,
+		`{"account_id":"acc_alice","amount":"100"}`,
+		`{"amount":100}`,
+		`not json`,
+	} {
+		w := browser.put(t, "/api/pots/pot_1/deposit", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "Body %s", body)
+	}
+	assert.Equal(t, int64(1000), monzo.potBalance)
+}
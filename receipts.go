@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// itemisation is the simple receipt our expense tooling sends, as JSON or as
+// CSV. Amounts are in minor units and are for the whole line.
+type itemisation struct {
+	// ExternalID identifies the receipt, so sending it again replaces it.
+	// It defaults to one derived from the transaction ID.
+	ExternalID string         `json:"external_id"`
+	Merchant   string         `json:"merchant"`
+	Items      []itemisedLine `json:"items"`
+	Taxes      []itemisedLine `json:"taxes"`
+}
+
+type itemisedLine struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Amount      int64   `json:"amount"`
+}
+
+// receiptExternalID is the external ID of a transaction's receipt when none
+// is given.
+func receiptExternalID(c *gin.Context, externalID string) string {
+	if externalID != "" {
+		return externalID
+	}
+	if externalID = c.Query("external_id"); externalID != "" {
+		return externalID
+	}
+	return "askmonzo_" + c.Param("id")
+}
+
+// parseItemisationCSV reads items from CSV with a header row naming the
+// description and amount columns, and optionally quantity.
+func parseItemisationCSV(r io.Reader) ([]itemisedLine, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("CSV needs a header row and at least one item")
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	description, hasDescription := columns["description"]
+	amount, hasAmount := columns["amount"]
+	quantity, hasQuantity := columns["quantity"]
+	if !hasDescription || !hasAmount {
+		return nil, fmt.Errorf("CSV header must name description and amount columns")
+	}
+
+	var items []itemisedLine
+	for n, row := range rows[1:] {
+		item := itemisedLine{Description: strings.TrimSpace(row[description]), Quantity: 1}
+
+		item.Amount, err = strconv.ParseInt(strings.TrimSpace(row[amount]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: amount must be a whole number of minor units", n+2)
+		}
+		if hasQuantity && strings.TrimSpace(row[quantity]) != "" {
+			item.Quantity, err = strconv.ParseFloat(strings.TrimSpace(row[quantity]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: quantity must be a number", n+2)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// buildReceipt turns an itemisation into a receipt for tx, checking it adds
+// up to what was paid.
+func buildReceipt(tx *monzo.Transaction, externalID string, in itemisation) (*monzo.Receipt, error) {
+	total := -tx.Amount
+	if total <= 0 {
+		return nil, fmt.Errorf("Receipts can only be added to payments")
+	}
+	if len(in.Items) == 0 {
+		return nil, fmt.Errorf("A receipt needs at least one item")
+	}
+
+	receipt := &monzo.Receipt{
+		TransactionID: tx.ID,
+		ExternalID:    externalID,
+		Total:         total,
+		Currency:      tx.Currency,
+		Payments: []monzo.ReceiptPayment{
+			{Type: monzo.PaymentTypeCard, Amount: total, Currency: tx.Currency},
+		},
+	}
+	if in.Merchant != "" {
+		receipt.Merchant = &monzo.ReceiptMerchant{Name: in.Merchant}
+	}
+
+	var sum int64
+	for _, item := range in.Items {
+		if item.Description == "" || item.Quantity < 0 {
+			return nil, fmt.Errorf("Every item needs a description and a quantity that isn't negative")
+		}
+		sum += item.Amount
+		receipt.Items = append(receipt.Items, monzo.ReceiptItem{
+			Description: item.Description,
+			Amount:      item.Amount,
+			Currency:    tx.Currency,
+			Quantity:    item.Quantity,
+		})
+	}
+	if sum != total {
+		return nil, fmt.Errorf("Items add up to %s but %s was paid", formatMoney(sum, tx.Currency), formatMoney(total, tx.Currency))
+	}
+
+	for _, tax := range in.Taxes {
+		receipt.Taxes = append(receipt.Taxes, monzo.ReceiptTax{
+			Description: tax.Description,
+			Amount:      tax.Amount,
+			Currency:    tx.Currency,
+		})
+	}
+	return receipt, nil
+}
+
+// putReceiptHandlerWrapper attaches an itemised receipt to one of the user's
+// transactions. The body is an itemisation as JSON, or as text/csv with the
+// external_id and merchant in the query.
+func putReceiptHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var in itemisation
+		var err error
+		if strings.HasPrefix(c.ContentType(), "text/csv") {
+			in.Merchant = c.Query("merchant")
+			in.Items, err = parseItemisationCSV(c.Request.Body)
+		} else {
+			err = json.NewDecoder(c.Request.Body).Decode(&in)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": fmt.Sprintf("Failed to read the itemisation: %s", err),
+			})
+			return
+		}
+
+		ctx := c.Request.Context()
+		client := userClient(c, api)
+		tx, err := client.Transaction(ctx, c.Param("id"))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		receipt, err := buildReceipt(tx, receiptExternalID(c, in.ExternalID), in)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"Error": err.Error(),
+			})
+			return
+		}
+
+		err = client.CreateReceipt(ctx, receipt)
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"receipt": receipt,
+		})
+	}
+}
+
+// receiptHandlerWrapper shows the receipt attached to one of the user's
+// transactions.
+func receiptHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		receipt, err := userClient(c, api).Receipt(c.Request.Context(), receiptExternalID(c, ""))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"receipt": receipt,
+		})
+	}
+}
+
+// deleteReceiptHandlerWrapper removes the receipt from one of the user's
+// transactions.
+func deleteReceiptHandlerWrapper(api *monzo.Client) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		err := userClient(c, api).DeleteReceipt(c.Request.Context(), receiptExternalID(c, ""))
+		if err != nil {
+			monzoErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "receipt removed",
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseItemisationCSV(t *testing.T) {
+	items, err := parseItemisationCSV(strings.NewReader("Description,Quantity,Amount\nCoffee,2,500\nCroissant,,150\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []itemisedLine{
+		{Description: "Coffee", Quantity: 2, Amount: 500},
+		{Description: "Croissant", Quantity: 1, Amount: 150},
+	}, items)
+
+	for _, bad := range []string{
+		"description,amount\n",
+		"name,price\nCoffee,500\n",
+		"description,amount\nCoffee,5.00\n",
+		"description,amount,quantity\nCoffee,500,two\n",
+		"description,amount\nCoffee,500,extra\n",
+	} {
+		_, err := parseItemisationCSV(strings.NewReader(bad))
+		assert.Error(t, err, "CSV %q", bad)
+	}
+}
+
+func TestBuildReceipt(t *testing.T) {
+	tx := &monzo.Transaction{ID: "tx_1", Amount: -650, Currency: "GBP"}
+
+	receipt, err := buildReceipt(tx, "receipt_1", itemisation{
+		Merchant: "Pret",
+		Items:    []itemisedLine{{Description: "Coffee", Quantity: 2, Amount: 500}, {Description: "Croissant", Quantity: 1, Amount: 150}},
+		Taxes:    []itemisedLine{{Description: "VAT", Amount: 108}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(650), receipt.Total)
+	assert.Equal(t, "tx_1", receipt.TransactionID)
+	assert.Equal(t, "Pret", receipt.Merchant.Name)
+	assert.Equal(t, []monzo.ReceiptPayment{{Type: "card", Amount: 650, Currency: "GBP"}}, receipt.Payments)
+
+	_, err = buildReceipt(tx, "receipt_1", itemisation{Items: []itemisedLine{{Description: "Coffee", Amount: 500}}})
+	assert.EqualError(t, err, "Items add up to £5.00 but £6.50 was paid")
+
+	_, err = buildReceipt(&monzo.Transaction{Amount: 650}, "receipt_1", itemisation{Items: []itemisedLine{{Description: "Refund", Amount: 650}}})
+	assert.Error(t, err)
+}
+
+func TestReceiptRoutes(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+	fake.txMetadata["tx_1"] = map[string]string{}
+	fake.txAmounts["tx_1"] = -650
+
+	browser := &fakeBrowser{server: testServer(fake.config())}
+	browser.login(t, "alice")
+
+	w := browser.send(t, "PUT", "/api/transactions/tx_1/receipt?merchant=Pret", "text/csv", "description,quantity,amount\nCoffee,2,500\nCroissant,1,150\n")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Contains(t, fake.receipts, "askmonzo_tx_1") {
+		assert.Equal(t, "tx_1", fake.receipts["askmonzo_tx_1"]["transaction_id"])
+		assert.Len(t, fake.receipts["askmonzo_tx_1"]["items"], 2)
+	}
+
+	w = browser.put(t, "/api/transactions/tx_1/receipt", `{"external_id":"expenses-42","items":[{"description":"Lunch","amount":650}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, fake.receipts, "expenses-42")
+
+	w = browser.get(t, "/api/transactions/tx_1/receipt?external_id=expenses-42")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "Lunch"))
+
+	w = browser.put(t, "/api/transactions/tx_1/receipt", `{"items":[{"description":"Lunch","amount":600}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err := http.NewRequest("DELETE", "/api/transactions/tx_1/receipt", nil)
+	assert.NoError(t, err)
+	w = browser.do(t, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, fake.receipts, "askmonzo_tx_1")
+
+	w = browser.get(t, "/api/transactions/tx_1/receipt")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+const (
+	// defaultRefreshMargin is how long before expiry a token gets refreshed.
+	defaultRefreshMargin = 5 * time.Minute
+
+	refreshInterval = time.Minute
+	refreshAttempts = 3
+	refreshBackoff  = time.Second
+)
+
+// tokenRefresher renews every stored token shortly before it expires, so
+// that whatever reads the store next finds a live access token.
+type tokenRefresher struct {
+	store    TokenStore
+	refresh  func(token AuthResponse) (AuthResponse, error)
+	margin   time.Duration
+	interval time.Duration
+	attempts int
+	backoff  time.Duration
+	now      func() time.Time
+}
+
+func newTokenRefresher(store TokenStore, margin time.Duration, refresh func(token AuthResponse) (AuthResponse, error)) *tokenRefresher {
+	return &tokenRefresher{
+		store:    store,
+		refresh:  refresh,
+		margin:   margin,
+		interval: refreshInterval,
+		attempts: refreshAttempts,
+		backoff:  refreshBackoff,
+		now:      time.Now,
+	}
+}
+
+// Run checks the store every interval until stop is closed.
+func (r *tokenRefresher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.refreshDue(stop)
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// refreshDue refreshes every token that expires within the margin.
+func (r *tokenRefresher) refreshDue(stop <-chan struct{}) {
+	keys, err := r.store.Keys()
+	if err != nil {
+		fmt.Printf("Failed to list tokens to refresh: %s\n", err)
+		return
+	}
+
+	deadline := r.now().Add(r.margin).Unix()
+	for _, key := range keys {
+		token, err := r.store.Load(key)
+		if err != nil {
+			fmt.Printf("Failed to load token for %s: %s\n", key, err)
+			continue
+		}
+
+		if token.RefreshToken == "" || token.AuthExpiryTimestamp > deadline {
+			continue
+		}
+
+		err = r.refreshWithRetry(token, stop)
+		if monzo.IsInvalidGrant(err) {
+			// Nobody can use this token again, the user has to log in afresh
+			fmt.Printf("Refresh token for %s rejected, dropping it: %s\n", key, err)
+			err = r.store.Delete(key)
+		}
+		if err != nil {
+			fmt.Printf("Failed to refresh token for %s: %s\n", key, err)
+		}
+	}
+}
+
+// refreshWithRetry tries to refresh token up to attempts times, doubling the
+// wait between tries. It gives up at once if Monzo rejects the refresh token.
+func (r *tokenRefresher) refreshWithRetry(token AuthResponse, stop <-chan struct{}) error {
+	wait := r.backoff
+
+	var err error
+	for attempt := 1; attempt <= r.attempts; attempt++ {
+		_, err = r.refresh(token)
+		if err == nil || monzo.IsInvalidGrant(err) || attempt == r.attempts {
+			break
+		}
+
+		fmt.Printf("Refreshing token for %s failed, attempt %d of %d: %s\n", token.UserID, attempt, r.attempts, err)
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return err
+		}
+		wait *= 2
+	}
+	return err
+}
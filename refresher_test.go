@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefresherOnlyRefreshesTokensNearExpiry(t *testing.T) {
+	now := time.Now()
+	store := newMemoryTokenStore()
+	store.Save("due", AuthResponse{UserID: "due", RefreshToken: "r1", AuthExpiryTimestamp: now.Add(time.Minute).Unix()})
+	store.Save("fresh", AuthResponse{UserID: "fresh", RefreshToken: "r2", AuthExpiryTimestamp: now.Add(time.Hour).Unix()})
+	store.Save("no-refresh", AuthResponse{UserID: "no-refresh", AuthExpiryTimestamp: now.Unix()})
+
+	var refreshed []string
+	refresher := newTokenRefresher(store, 5*time.Minute, func(token AuthResponse) (AuthResponse, error) {
+		refreshed = append(refreshed, token.UserID)
+		return token, nil
+	})
+	refresher.now = func() time.Time { return now }
+
+	refresher.refreshDue(nil)
+
+	assert.Equal(t, []string{"due"}, refreshed)
+}
+
+func TestRefresherRetriesWithBackoff(t *testing.T) {
+	store := newMemoryTokenStore()
+	store.Save("due", AuthResponse{UserID: "due", RefreshToken: "r1"})
+
+	calls := 0
+	refresher := newTokenRefresher(store, time.Minute, func(token AuthResponse) (AuthResponse, error) {
+		calls++
+		if calls < 3 {
+			return AuthResponse{}, errors.New("monzo is down")
+		}
+		return token, nil
+	})
+	refresher.backoff = time.Millisecond
+
+	refresher.refreshDue(nil)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRefresherGivesUp(t *testing.T) {
+	calls := 0
+	refresher := newTokenRefresher(newMemoryTokenStore(), time.Minute, func(token AuthResponse) (AuthResponse, error) {
+		calls++
+		return AuthResponse{}, errors.New("monzo is down")
+	})
+	refresher.backoff = time.Millisecond
+
+	err := refresher.refreshWithRetry(AuthResponse{UserID: "due", RefreshToken: "r1"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, refresher.attempts, calls)
+}
+
+func TestRefresherDropsRejectedTokens(t *testing.T) {
+	store := newMemoryTokenStore()
+	store.Save("due", AuthResponse{UserID: "due", RefreshToken: "r1"})
+
+	calls := 0
+	refresher := newTokenRefresher(store, time.Minute, func(token AuthResponse) (AuthResponse, error) {
+		calls++
+		return AuthResponse{}, &monzo.OAuthError{StatusCode: 400, Code: "invalid_grant"}
+	})
+
+	refresher.refreshDue(nil)
+
+	assert.Equal(t, 1, calls, "A rejected refresh token should not be retried")
+	_, err := store.Load("due")
+	assert.Equal(t, ErrTokenNotFound, err)
+}
+
+func TestRefresherStops(t *testing.T) {
+	_, refresher := newServer(testConfig())
+	refresher.interval = time.Millisecond
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		refresher.Run(stop)
+		close(done)
+	}()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The refresher kept running after being stopped")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookieName = "askmonzo_session"
+	sessionContextKey = "session"
+
+	// sessionMaxAge is how long, in seconds, a browser keeps its session cookie.
+	sessionMaxAge = 30 * 24 * 60 * 60
+)
+
+var errInvalidSession = errors.New("invalid session cookie")
+
+// session is kept in the browser's cookie. It is signed but not encrypted,
+// so it must never hold anything secret. Expires is signed along with the
+// rest, so a copied cookie stops working then whatever the browser does.
+type session struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"user_id,omitempty"`
+	Issued  time.Time `json:"issued"`
+	Expires time.Time `json:"expires"`
+}
+
+// sessionManager signs and verifies session cookies so that each browser is
+// tied to its own Monzo user.
+type sessionManager struct {
+	secret []byte
+	// secure reports whether the request came in over https, in which case
+	// the cookie is only ever sent back over https.
+	secure func(c *gin.Context) bool
+	now    func() time.Time
+}
+
+func newSessionManager(secret []byte, secure func(c *gin.Context) bool) *sessionManager {
+	return &sessionManager{secret: secret, secure: secure, now: time.Now}
+}
+
+// middleware loads the session from the request cookie, starting a new one
+// when the cookie is missing or has been tampered with.
+func (m *sessionManager) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sess session
+
+		value, err := c.Cookie(sessionCookieName)
+		if err == nil {
+			sess, err = m.decode(value)
+		}
+		if err != nil {
+			sess = session{ID: randomToken(), Issued: m.now(), Expires: m.expiry()}
+			m.save(c, &sess)
+		}
+
+		c.Set(sessionContextKey, &sess)
+		c.Next()
+	}
+}
+
+// save writes sess back to the browser.
+func (m *sessionManager) save(c *gin.Context, sess *session) {
+	value, err := m.encode(*sess)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	m.setCookie(c, value, sessionMaxAge)
+}
+
+// login ties the session to userID, starting its time afresh.
+func (m *sessionManager) login(c *gin.Context, sess *session, userID string) {
+	sess.UserID = userID
+	sess.Issued = m.now()
+	sess.Expires = m.expiry()
+	m.save(c, sess)
+}
+
+// expiry is when a session started now stops being accepted.
+func (m *sessionManager) expiry() time.Time {
+	return m.now().Add(sessionMaxAge * time.Second).UTC()
+}
+
+// clear removes the session cookie and forgets the user in the current request.
+func (m *sessionManager) clear(c *gin.Context) {
+	sess := getSession(c)
+	sess.UserID = ""
+	m.setCookie(c, "", -1)
+}
+
+// setCookie writes the session cookie. It goes through http.SetCookie because
+// gin's SetCookie can't set SameSite, and Lax keeps the cookie off cross-site
+// POSTs such as one to /auth/logout.
+func (m *sessionManager) setCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    url.QueryEscape(value),
+		MaxAge:   maxAge,
+		Path:     "/",
+		Secure:   m.secure != nil && m.secure(c),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (m *sessionManager) encode(sess session) (string, error) {
+	payload, err := json.Marshal(sess)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + m.sign(encoded), nil
+}
+
+func (m *sessionManager) decode(value string) (session, error) {
+	var sess session
+
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(m.sign(parts[0]))) {
+		return sess, errInvalidSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return sess, errInvalidSession
+	}
+
+	err = json.Unmarshal(payload, &sess)
+	if err != nil || sess.ID == "" || !m.now().Before(sess.Expires) {
+		return sess, errInvalidSession
+	}
+	return sess, nil
+}
+
+func (m *sessionManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// loadUserToken loads the token of the user logged in to sess. Logging out
+// deletes the token, and the next login starts a new SessionsSince, so a
+// session from before the user last logged out, such as a copied cookie,
+// gets ErrTokenNotFound as if nobody had logged in to it.
+func loadUserToken(store TokenStore, sess *session) (AuthResponse, error) {
+	if sess.UserID == "" {
+		return AuthResponse{}, ErrTokenNotFound
+	}
+
+	token, err := store.Load(sess.UserID)
+	if err == nil && sess.Issued.Before(token.SessionsSince) {
+		return AuthResponse{}, ErrTokenNotFound
+	}
+	return token, err
+}
+
+// getSession returns the session loaded by sessionManager.middleware.
+func getSession(c *gin.Context) *session {
+	return c.MustGet(sessionContextKey).(*session)
+}
+
+// randomToken returns 32 bytes from crypto/rand, URL-safe encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
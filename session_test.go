@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	sessions := newSessionManager([]byte("secret"), nil)
+	expires := time.Now().Add(time.Hour).UTC().Round(time.Second)
+
+	value, err := sessions.encode(session{ID: "id", UserID: "user_123", Expires: expires})
+	assert.NoError(t, err)
+
+	sess, err := sessions.decode(value)
+	assert.NoError(t, err)
+	assert.Equal(t, session{ID: "id", UserID: "user_123", Expires: expires}, sess)
+}
+
+func TestSessionExpires(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	sessions := newSessionManager([]byte("secret"), nil)
+	sessions.now = func() time.Time { return now }
+
+	value, err := sessions.encode(session{ID: "id", UserID: "user_123", Expires: sessions.expiry()})
+	assert.NoError(t, err)
+
+	now = now.Add(sessionMaxAge*time.Second - time.Second)
+	_, err = sessions.decode(value)
+	assert.NoError(t, err)
+
+	now = now.Add(time.Second)
+	_, err = sessions.decode(value)
+	assert.Equal(t, errInvalidSession, err)
+
+	// Cookies from before sessions expired are no good either
+	value, err = sessions.encode(session{ID: "id", UserID: "user_123"})
+	assert.NoError(t, err)
+	_, err = sessions.decode(value)
+	assert.Equal(t, errInvalidSession, err)
+}
+
+func TestSessionRejectsTampering(t *testing.T) {
+	sessions := newSessionManager([]byte("secret"), nil)
+
+	value, err := newSessionManager([]byte("other secret"), nil).encode(session{ID: "id", UserID: "user_123", Expires: sessions.expiry()})
+	assert.NoError(t, err)
+
+	_, err = sessions.decode(value)
+	assert.Equal(t, errInvalidSession, err)
+
+	_, err = sessions.decode("garbage")
+	assert.Equal(t, errInvalidSession, err)
+}
+
+func TestSessionMiddlewareIssuesCookie(t *testing.T) {
+	sessions := newSessionManager([]byte("secret"), nil)
+
+	router := gin.New()
+	router.GET("/", sessions.middleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, getSession(c).ID)
+	})
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/", nil)
+	assert.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, sessionCookieName, cookies[0].Name)
+	}
+	firstID := w.Body.String()
+	assert.NotEmpty(t, firstID)
+
+	// Sending the cookie back keeps the same session.
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/", nil)
+	assert.NoError(t, err)
+	req.AddCookie(cookies[0])
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, firstID, w.Body.String())
+	assert.Empty(t, w.Result().Cookies())
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	for _, secure := range []bool{false, true} {
+		sessions := newSessionManager([]byte("secret"), func(c *gin.Context) bool {
+			return secure
+		})
+
+		router := gin.New()
+		router.GET("/", sessions.middleware(), func(c *gin.Context) {})
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/", nil)
+		assert.NoError(t, err)
+		router.ServeHTTP(w, req)
+
+		cookies := w.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.Equal(t, secure, cookies[0].Secure)
+			assert.True(t, cookies[0].HttpOnly)
+			assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+			assert.Equal(t, "/", cookies[0].Path)
+		}
+	}
+}
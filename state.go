@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// stateTTL is how long a user has to get from /auth to /auth/callback.
+const stateTTL = 10 * time.Minute
+
+type pendingLogin struct {
+	sessionID    string
+	codeVerifier string
+	expires      time.Time
+}
+
+// stateManager issues the OAuth state parameter. Each state is random, tied
+// to the session that asked for it, expires after a while and can only be
+// used once. With pkce set it also keeps a PKCE code_verifier for each login.
+type stateManager struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	pkce   bool
+	logins map[string]pendingLogin
+	now    func() time.Time
+}
+
+func newStateManager(ttl time.Duration, pkce bool) *stateManager {
+	return &stateManager{
+		ttl:    ttl,
+		pkce:   pkce,
+		logins: map[string]pendingLogin{},
+		now:    time.Now,
+	}
+}
+
+// Issue returns a fresh state for sessionID, and the PKCE code_challenge to
+// send with it when PKCE is on.
+func (m *stateManager) Issue(sessionID string) (state, challenge string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for issued, login := range m.logins {
+		if now.After(login.expires) {
+			delete(m.logins, issued)
+		}
+	}
+
+	login := pendingLogin{
+		sessionID: sessionID,
+		expires:   now.Add(m.ttl),
+	}
+	if m.pkce {
+		login.codeVerifier = newCodeVerifier()
+		challenge = codeChallenge(login.codeVerifier)
+	}
+
+	state = randomToken()
+	m.logins[state] = login
+	return state, challenge
+}
+
+// Consume reports whether state was issued to sessionID and has not expired,
+// returning the login's PKCE code_verifier if it has one. A state is
+// forgotten once consumed, whether or not it was valid.
+func (m *stateManager) Consume(state, sessionID string) (codeVerifier string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	login, ok := m.logins[state]
+	if !ok {
+		return "", false
+	}
+	delete(m.logins, state)
+
+	if login.sessionID != sessionID || m.now().After(login.expires) {
+		return "", false
+	}
+	return login.codeVerifier, true
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateIsSingleUse(t *testing.T) {
+	states := newStateManager(time.Minute, false)
+
+	state, _ := states.Issue("session")
+	other, _ := states.Issue("session")
+	assert.NotEqual(t, state, other, "Every login should get its own state")
+
+	_, ok := states.Consume(state, "session")
+	assert.True(t, ok)
+	_, ok = states.Consume(state, "session")
+	assert.False(t, ok, "A state should not be accepted twice")
+}
+
+func TestStateIsBoundToSession(t *testing.T) {
+	states := newStateManager(time.Minute, false)
+
+	state, _ := states.Issue("session")
+	_, ok := states.Consume(state, "another session")
+	assert.False(t, ok)
+	_, ok = states.Consume(state, "session")
+	assert.False(t, ok, "A state should be dropped after a failed attempt")
+}
+
+func TestStateExpires(t *testing.T) {
+	now := time.Now()
+	states := newStateManager(time.Minute, false)
+	states.now = func() time.Time { return now }
+
+	state, _ := states.Issue("session")
+	now = now.Add(2 * time.Minute)
+
+	_, ok := states.Consume(state, "session")
+	assert.False(t, ok)
+}
+
+func TestStateKeepsPKCEVerifier(t *testing.T) {
+	states := newStateManager(time.Minute, true)
+
+	state, challenge := states.Issue("session")
+	verifier, ok := states.Consume(state, "session")
+	assert.True(t, ok)
+	assert.Equal(t, codeChallenge(verifier), challenge)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// The example from RFC 7636 appendix B.
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrTokenNotFound is returned by a TokenStore when nothing is stored under a key.
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore keeps AuthResponses around between requests and, depending on
+// the implementation, between restarts.
+type TokenStore interface {
+	Load(key string) (AuthResponse, error)
+	Save(key string, token AuthResponse) error
+	Delete(key string) error
+	Keys() ([]string, error)
+}
+
+// newTokenStore builds the TokenStore cfg asks for. An empty type gives the
+// in-memory store. Stores that write to disk encrypt tokens when cfg has
+// encryption keys.
+func newTokenStore(cfg StorageConfig) (TokenStore, error) {
+	var codec tokenCodec = plainCodec{}
+	if len(cfg.EncryptionKeys) > 0 {
+		sealed, err := newSealedCodec(cfg.EncryptionKeys, cfg.currentKeyVersion())
+		if err != nil {
+			return nil, err
+		}
+		codec = sealed
+	}
+
+	path := cfg.Path
+	switch cfg.Type {
+	case "", "memory":
+		return newMemoryTokenStore(), nil
+	case "file":
+		if path == "" {
+			path = "tokens.json"
+		}
+		return newFileTokenStore(path, codec), nil
+	case "bolt":
+		if path == "" {
+			path = "tokens.db"
+		}
+		return newBoltTokenStore(path, codec)
+	default:
+		return nil, fmt.Errorf("unknown token store %q", cfg.Type)
+	}
+}
+
+type memoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]AuthResponse
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{tokens: map[string]AuthResponse{}}
+}
+
+func (s *memoryTokenStore) Load(key string) (AuthResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[key]
+	if !ok {
+		return AuthResponse{}, ErrTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *memoryTokenStore) Save(key string, token AuthResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[key] = token
+	return nil
+}
+
+func (s *memoryTokenStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, key)
+	return nil
+}
+
+func (s *memoryTokenStore) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.tokens))
+	for key := range s.tokens {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// fileTokenStore keeps every token in a single JSON file, rewritten whole on
+// each change. It is meant for a handful of users, not a busy deployment.
+type fileTokenStore struct {
+	mu    sync.Mutex
+	path  string
+	codec tokenCodec
+}
+
+func newFileTokenStore(path string, codec tokenCodec) *fileTokenStore {
+	return &fileTokenStore{path: path, codec: codec}
+}
+
+func (s *fileTokenStore) Load(key string) (AuthResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	data, ok := tokens[key]
+	if !ok {
+		return AuthResponse{}, ErrTokenNotFound
+	}
+	return s.codec.Decode(key, data)
+}
+
+func (s *fileTokenStore) Save(key string, token AuthResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.codec.Encode(key, token)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	tokens[key] = data
+	return s.write(tokens)
+}
+
+func (s *fileTokenStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	delete(tokens, key)
+	return s.write(tokens)
+}
+
+func (s *fileTokenStore) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(tokens))
+	for key := range tokens {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// read returns every record in the file, still encoded.
+func (s *fileTokenStore) read() (map[string]json.RawMessage, error) {
+	tokens := map[string]json.RawMessage{}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &tokens)
+	return tokens, err
+}
+
+// write replaces the file atomically so a crash never leaves half a file behind.
+func (s *fileTokenStore) write(tokens map[string]json.RawMessage) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".tokens")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+var tokenBucket = []byte("tokens")
+
+type boltTokenStore struct {
+	db    *bolt.DB
+	codec tokenCodec
+}
+
+func newBoltTokenStore(path string, codec tokenCodec) (*boltTokenStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokenBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltTokenStore{db: db, codec: codec}, nil
+}
+
+func (s *boltTokenStore) Load(key string) (AuthResponse, error) {
+	var token AuthResponse
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tokenBucket).Get([]byte(key))
+		if data == nil {
+			return ErrTokenNotFound
+		}
+
+		var err error
+		token, err = s.codec.Decode(key, data)
+		return err
+	})
+	return token, err
+}
+
+func (s *boltTokenStore) Save(key string, token AuthResponse) error {
+	data, err := s.codec.Encode(key, token)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokenBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *boltTokenStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokenBucket).Delete([]byte(key))
+	})
+}
+
+func (s *boltTokenStore) Keys() ([]string, error) {
+	var keys []string
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokenBucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *boltTokenStore) Close() error {
+	return s.db.Close()
+}
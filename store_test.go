@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTokenStore(t *testing.T, store TokenStore) {
+	_, err := store.Load("alice")
+	assert.Equal(t, ErrTokenNotFound, err)
+
+	token := AuthResponse{AccessToken: "access", RefreshToken: "refresh", UserID: "alice", AuthExpiryTimestamp: 42}
+	assert.NoError(t, store.Save("alice", token))
+	assert.NoError(t, store.Save("bob", AuthResponse{AccessToken: "other"}))
+
+	loaded, err := store.Load("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, token, loaded)
+
+	keys, err := store.Keys()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice", "bob"}, keys)
+
+	assert.NoError(t, store.Delete("alice"))
+	_, err = store.Load("alice")
+	assert.Equal(t, ErrTokenNotFound, err)
+
+	loaded, err = store.Load("bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", loaded.AccessToken)
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	testTokenStore(t, newMemoryTokenStore())
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.json")
+	testTokenStore(t, newFileTokenStore(path, plainCodec{}))
+
+	// A fresh store on the same file sees what the last one wrote.
+	loaded, err := newFileTokenStore(path, plainCodec{}).Load("bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", loaded.AccessToken)
+}
+
+func TestBoltTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askmonzo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.db")
+	store, err := newBoltTokenStore(path, plainCodec{})
+	assert.NoError(t, err)
+	testTokenStore(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = newBoltTokenStore(path, plainCodec{})
+	assert.NoError(t, err)
+	defer store.Close()
+
+	loaded, err := store.Load("bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", loaded.AccessToken)
+}
+
+func TestNewTokenStoreRejectsUnknownKind(t *testing.T) {
+	_, err := newTokenStore(StorageConfig{Type: "redis"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// tokenManager is how the server gets at users' tokens. It is a TokenStore in
+// its own right, passing reads straight through so they run in parallel, but
+// refreshes go through Refresh, which lets only one refresh per user run at
+// a time.
+type tokenManager struct {
+	TokenStore
+
+	refresh func(token AuthResponse) (AuthResponse, error)
+
+	mu      sync.Mutex
+	flights map[string]*refreshFlight
+
+	// rotating is held for writing while keys are rotated, so that no token
+	// written in the meantime is overwritten with an older one.
+	rotating sync.RWMutex
+}
+
+// refreshFlight is a refresh in progress. done is closed once token and err
+// are set.
+type refreshFlight struct {
+	done  chan struct{}
+	token AuthResponse
+	err   error
+}
+
+// newTokenManager wraps store. refresh should swap a token's refresh token
+// for a new token and save it.
+func newTokenManager(store TokenStore, refresh func(token AuthResponse) (AuthResponse, error)) *tokenManager {
+	return &tokenManager{
+		TokenStore: store,
+		refresh:    refresh,
+		flights:    map[string]*refreshFlight{},
+	}
+}
+
+// Refresh renews stale, the token the caller found had expired. Callers that
+// arrive while the user's token is already being refreshed wait for that
+// refresh and share its result. A caller that turns up after someone else
+// has refreshed gets the newer token without another trip to Monzo, which
+// matters because Monzo only accepts each refresh token once.
+func (m *tokenManager) Refresh(stale AuthResponse) (AuthResponse, error) {
+	m.mu.Lock()
+	if flight, ok := m.flights[stale.UserID]; ok {
+		m.mu.Unlock()
+		<-flight.done
+		return flight.token, flight.err
+	}
+
+	flight := &refreshFlight{done: make(chan struct{})}
+	m.flights[stale.UserID] = flight
+	m.mu.Unlock()
+
+	m.rotating.RLock()
+	flight.token, flight.err = m.refreshLatest(stale)
+	m.rotating.RUnlock()
+
+	m.mu.Lock()
+	delete(m.flights, stale.UserID)
+	m.mu.Unlock()
+	close(flight.done)
+
+	return flight.token, flight.err
+}
+
+func (m *tokenManager) Save(key string, token AuthResponse) error {
+	m.rotating.RLock()
+	defer m.rotating.RUnlock()
+
+	return m.TokenStore.Save(key, token)
+}
+
+func (m *tokenManager) Delete(key string) error {
+	m.rotating.RLock()
+	defer m.rotating.RUnlock()
+
+	return m.TokenStore.Delete(key)
+}
+
+// RotateKeys re-encrypts every stored token with the current key, holding off
+// refreshes, logins and logouts until it is done.
+func (m *tokenManager) RotateKeys() (int, error) {
+	m.rotating.Lock()
+	defer m.rotating.Unlock()
+
+	return rotateTokenKeys(m.TokenStore)
+}
+
+func (m *tokenManager) refreshLatest(stale AuthResponse) (AuthResponse, error) {
+	current, err := m.Load(stale.UserID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	if current.RefreshToken != stale.RefreshToken {
+		return current, nil
+	}
+	return m.refresh(current)
+}
+
+// TokenSource is a monzo.TokenSource for the user's stored token, for work
+// done on their behalf outside a request. An expired token is refreshed
+// first.
+func (m *tokenManager) TokenSource(userID string) monzo.TokenSource {
+	return userToken{tokens: m, userID: userID}
+}
+
+type userToken struct {
+	tokens *tokenManager
+	userID string
+}
+
+func (u userToken) Token(ctx context.Context) (string, error) {
+	token, err := u.tokens.Load(u.userID)
+	if err != nil {
+		return "", err
+	}
+
+	if token.AuthExpiryTimestamp <= time.Now().Unix() && token.RefreshToken != "" {
+		token, err = u.tokens.Refresh(token)
+		if err != nil {
+			return "", err
+		}
+	}
+	return token.AccessToken, nil
+}
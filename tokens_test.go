@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenManagerSharesInFlightRefresh(t *testing.T) {
+	store := newMemoryTokenStore()
+	stale := AuthResponse{UserID: "alice", AccessToken: "a1", RefreshToken: "r1"}
+	store.Save("alice", stale)
+
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	tokens := newTokenManager(store, func(token AuthResponse) (AuthResponse, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+
+		<-release
+		fresh := AuthResponse{UserID: "alice", AccessToken: "a2", RefreshToken: "r2"}
+		return fresh, store.Save("alice", fresh)
+	})
+
+	var wg sync.WaitGroup
+	results := make(chan AuthResponse, 10)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := tokens.Refresh(stale)
+			assert.NoError(t, err)
+			results <- token
+		}()
+	}
+
+	// Let the callers pile up behind the first refresh before it finishes.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for token := range results {
+		assert.Equal(t, "a2", token.AccessToken)
+	}
+	assert.Equal(t, 1, calls)
+
+	// Someone who saw the old token only now still gets the new one.
+	token, err := tokens.Refresh(stale)
+	assert.NoError(t, err)
+	assert.Equal(t, "a2", token.AccessToken)
+	assert.Equal(t, 1, calls)
+}
+
+func TestTokenManagerRefreshAfterLogout(t *testing.T) {
+	tokens := newTokenManager(newMemoryTokenStore(), func(token AuthResponse) (AuthResponse, error) {
+		t.Error("A logged out user's token should not be refreshed")
+		return token, nil
+	})
+
+	_, err := tokens.Refresh(AuthResponse{UserID: "alice", RefreshToken: "r1"})
+	assert.Equal(t, ErrTokenNotFound, err)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+)
+
+// webhookPath is where Monzo posts events, followed by the user's secret.
+const webhookPath = "/webhooks/monzo/"
+
+// transactionHandler reacts to a transaction.created event for the user.
+type transactionHandler func(ctx context.Context, userID string, tx *monzo.Transaction)
+
+// webhookManager registers webhooks for users' accounts and dispatches the
+// events Monzo sends to them. Each user's webhook URL ends in a secret that
+// names the user and is signed, so nobody else can post events for them.
+type webhookManager struct {
+	api    *monzo.Client
+	secret []byte
+
+	mu sync.Mutex
+	// registered holds the users whose webhooks are known to be in place,
+	// so that each login doesn't ask Monzo again.
+	registered map[string]bool
+	onTx       []transactionHandler
+}
+
+func newWebhookManager(api *monzo.Client, secret []byte) *webhookManager {
+	return &webhookManager{
+		api:        api,
+		secret:     secret,
+		registered: map[string]bool{},
+	}
+}
+
+// OnTransactionCreated adds handler to those called for every new
+// transaction.
+func (m *webhookManager) OnTransactionCreated(handler transactionHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onTx = append(m.onTx, handler)
+}
+
+// Register makes sure each of the user's open accounts has a webhook
+// pointing at us under baseURL, dropping any of the user's left behind with
+// an old URL. Monzo lists the webhooks of everyone on a joint account, and
+// theirs are left alone.
+func (m *webhookManager) Register(ctx context.Context, token AuthResponse, baseURL string) error {
+	userID := token.UserID
+
+	m.mu.Lock()
+	done := m.registered[userID]
+	m.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	client := m.api.WithTokens(monzo.StaticToken(token.AccessToken))
+	accounts, err := client.Accounts(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	webhookURL := baseURL + webhookPath + m.userSecret(userID)
+	for _, account := range accounts {
+		if account.Closed {
+			continue
+		}
+
+		webhooks, err := client.Webhooks(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, webhook := range webhooks {
+			switch {
+			case webhook.URL == webhookURL:
+				found = true
+			case strings.HasPrefix(webhook.URL, baseURL+webhookPath) && m.ownedBy(webhook.URL, userID):
+				err = client.DeleteWebhook(ctx, webhook.ID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if !found {
+			_, err = client.RegisterWebhook(ctx, account.ID, webhookURL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.registered[userID] = true
+	m.mu.Unlock()
+	return nil
+}
+
+// Unregister deletes the user's webhooks from all their accounts, whatever
+// URL they were registered under, so that Register sets them up afresh at
+// the next login. It must be called while the token still works.
+func (m *webhookManager) Unregister(ctx context.Context, token AuthResponse) error {
+	m.mu.Lock()
+	delete(m.registered, token.UserID)
+	m.mu.Unlock()
+
+	client := m.api.WithTokens(monzo.StaticToken(token.AccessToken))
+	accounts, err := client.Accounts(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		webhooks, err := client.Webhooks(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, webhook := range webhooks {
+			if m.ownedBy(webhook.URL, token.UserID) {
+				err = client.DeleteWebhook(ctx, webhook.ID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ownedBy reports whether webhookURL is one of ours for userID. The signature
+// isn't checked, so that webhooks signed with an older secret are found too,
+// but only the user named in the URL matches.
+func (m *webhookManager) ownedBy(webhookURL, userID string) bool {
+	u, err := url.Parse(webhookURL)
+	if err != nil || !strings.HasPrefix(u.Path, webhookPath) {
+		return false
+	}
+
+	encoded := strings.SplitN(strings.TrimPrefix(u.Path, webhookPath), ".", 2)[0]
+	owner, err := base64.RawURLEncoding.DecodeString(encoded)
+	return err == nil && string(owner) == userID
+}
+
+// userSecret is the last part of the user's webhook URL.
+func (m *webhookManager) userSecret(userID string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(userID))
+	return encoded + "." + m.sign(encoded)
+}
+
+// userID checks a secret from a webhook URL and returns whose it is.
+func (m *webhookManager) userID(secret string) (string, bool) {
+	parts := strings.SplitN(secret, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(m.sign(parts[0]))) {
+		return "", false
+	}
+
+	userID, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(userID) == 0 {
+		return "", false
+	}
+	return string(userID), true
+}
+
+func (m *webhookManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte("webhook:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// receiveHandler takes events from Monzo. Events of types we don't handle
+// are accepted and dropped, so Monzo doesn't retry them.
+func (m *webhookManager) receiveHandler(c *gin.Context) {
+	userID, ok := m.userID(c.Param("secret"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"Error": "Unknown webhook",
+		})
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	event, err := monzo.ParseWebhookEvent(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch event.Type {
+	case monzo.EventTransactionCreated:
+		tx, err := event.Transaction()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		m.mu.Lock()
+		handlers := m.onTx
+		m.mu.Unlock()
+		for _, handler := range handlers {
+			handler(c.Request.Context(), userID, tx)
+		}
+	default:
+		fmt.Printf("Ignoring %s webhook event for %s\n", event.Type, userID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ok",
+	})
+}
+
+// logTransaction is a transactionHandler that notes each transaction in the
+// log. The log ends up with whoever hosts us, so it says nothing about what
+// was spent.
+func logTransaction(ctx context.Context, userID string, tx *monzo.Transaction) {
+	fmt.Printf("Transaction %s for %s\n", tx.ID, userID)
+}
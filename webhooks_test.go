@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jutkko/askmonzo/monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginRegistersWebhooks(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+
+	// One of alice's from before the secret changed
+	stale := "https://askmonzo.example.com/webhooks/monzo/" + newWebhookManager(nil, []byte("old secret")).userSecret("user_alice")
+	fake.webhooks = append(fake.webhooks, gin.H{"id": "webhook_old", "account_id": "acc_alice", "url": stale})
+	fake.webhooks = append(fake.webhooks, gin.H{"id": "webhook_other", "account_id": "acc_alice", "url": "https://elsewhere.example.com/hook"})
+
+	cfg := fake.config()
+	cfg.PublicURL = "https://askmonzo.example.com"
+	cfg.SessionSecret = "secret"
+	(&fakeBrowser{server: testServer(cfg)}).login(t, "alice")
+
+	if assert.Len(t, fake.webhooks, 2) {
+		assert.Equal(t, "webhook_other", fake.webhooks[0]["id"])
+		assert.True(t, strings.HasPrefix(fake.webhooks[1]["url"].(string), "https://askmonzo.example.com/webhooks/monzo/"))
+	}
+
+	// Another server with the same secret finds the webhook already there
+	(&fakeBrowser{server: testServer(cfg)}).login(t, "alice")
+	assert.Len(t, fake.webhooks, 2)
+	assert.Equal(t, 1, fake.webhookCount)
+}
+
+func TestWebhooksOnJointAccounts(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+	fake.jointAccount = "acc_joint"
+
+	cfg := fake.config()
+	cfg.PublicURL = "https://askmonzo.example.com"
+	cfg.SessionSecret = "secret"
+	cfg.AdminToken = "letmein"
+	server := testServer(cfg)
+	alice := &fakeBrowser{server: server}
+	alice.login(t, "alice")
+	(&fakeBrowser{server: server}).login(t, "bob")
+
+	// Each of them has a webhook on the joint account
+	joint := func() []string {
+		var urls []string
+		for _, webhook := range fake.webhooks {
+			if webhook["account_id"] == "acc_joint" {
+				urls = append(urls, webhook["url"].(string))
+			}
+		}
+		return urls
+	}
+	webhooks := newWebhookManager(nil, []byte("secret"))
+	assert.ElementsMatch(t, []string{
+		"https://askmonzo.example.com/webhooks/monzo/" + webhooks.userSecret("user_alice"),
+		"https://askmonzo.example.com/webhooks/monzo/" + webhooks.userSecret("user_bob"),
+	}, joint())
+
+	// Logging out takes only alice's away, and logging in again puts it back
+	alice.post(t, "/auth/logout", "")
+	assert.Equal(t, []string{"https://askmonzo.example.com/webhooks/monzo/" + webhooks.userSecret("user_bob")}, joint())
+	assert.Len(t, fake.webhooks, 2)
+	alice.login(t, "alice")
+	assert.Len(t, joint(), 2)
+	assert.Len(t, fake.webhooks, 4)
+
+	w := adminPost(t, server, "/admin/logout-all")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, fake.webhooks)
+}
+
+func TestWebhooksWaitForApproval(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+	fake.unapproved = true
+
+	cfg := fake.config()
+	cfg.WebhookSecret = "secret"
+	browser := &fakeBrowser{server: testServer(cfg)}
+	browser.login(t, "alice")
+	assert.Empty(t, fake.webhooks)
+
+	fake.unapproved = false
+	browser.get(t, "/auth/status")
+	assert.Len(t, fake.webhooks, 1)
+}
+
+func TestWebhooksNeedASecret(t *testing.T) {
+	fake := newFakeMonzo()
+	defer fake.Close()
+
+	server := testServer(fake.config())
+	(&fakeBrowser{server: server}).login(t, "alice")
+	assert.Empty(t, fake.webhooks)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", webhookPath+"anything", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWebhookDispatchesTransactions(t *testing.T) {
+	webhooks := newWebhookManager(nil, []byte("secret"))
+
+	type received struct {
+		userID string
+		tx     *monzo.Transaction
+	}
+	var got []received
+	webhooks.OnTransactionCreated(func(ctx context.Context, userID string, tx *monzo.Transaction) {
+		got = append(got, received{userID, tx})
+	})
+
+	router := gin.New()
+	router.POST(webhookPath+":secret", webhooks.receiveHandler)
+	post := func(secret, body string) int {
+		req, err := http.NewRequest("POST", webhookPath+secret, strings.NewReader(body))
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	alice := webhooks.userSecret("user_alice")
+	bob := webhooks.userSecret("user_bob")
+	event := `{"type":"transaction.created","data":{"id":"tx_1","amount":-350,"currency":"GBP"}}`
+
+	assert.Equal(t, http.StatusOK, post(alice, event))
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "user_alice", got[0].userID)
+		assert.Equal(t, "tx_1", got[0].tx.ID)
+		assert.Equal(t, int64(-350), got[0].tx.Amount)
+	}
+
+	// Bob's name with Alice's signature
+	forged := strings.SplitN(bob, ".", 2)[0] + "." + strings.SplitN(alice, ".", 2)[1]
+	for _, secret := range []string{"nonsense", forged, alice + "x"} {
+		assert.Equal(t, http.StatusNotFound, post(secret, event), "Secret %q", secret)
+	}
+
+	assert.Equal(t, http.StatusOK, post(alice, `{"type":"account.updated","data":{}}`))
+	assert.Equal(t, http.StatusBadRequest, post(alice, `not json`))
+	assert.Len(t, got, 1)
+}